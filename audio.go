@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+const (
+	channels  int = 2                   // 1 for mono, 2 for stereo
+	frameRate int = 48000               // audio sampling rate
+	frameSize int = 960                 // uint16 size of each audio frame
+	maxBytes  int = (frameSize * 2) * 2 // max size of opus data
+)
+
+// errNoResults is returned by lookupTrack when a search finds nothing
+var errNoResults = errors.New("no results")
+
+// audioSource produces the 20 ms opus frames sent by the voice connection
+type audioSource interface {
+	// readOpus returns the next opus frame or io.EOF when the audio ends
+	readOpus() ([]byte, error)
+	// close stops the source and cleans up any processes it started
+	close() error
+}
+
+// ffmpegSource decodes audio with ffmpeg and encodes the pcm output to opus
+type ffmpegSource struct {
+	cmd       *exec.Cmd
+	pcm       *bufio.Reader
+	encoder   *gopus.Encoder
+	audiobuf  []int16
+	closeOnce sync.Once
+}
+
+// newFFmpegSource starts ffmpeg reading the audio from in.
+func newFFmpegSource(in io.Reader) (*ffmpegSource, error) {
+	encoder, err := gopus.NewEncoder(frameRate, channels, gopus.Audio)
+	if err != nil {
+		return nil, fmt.Errorf("error creating encoder: %v", err)
+	}
+
+	cmd := exec.Command("ffmpeg", "-loglevel", "error", "-i", "pipe:0", "-f", "s16le", "-ar", strconv.Itoa(frameRate), "-ac", strconv.Itoa(channels), "pipe:1")
+	cmd.Stdin = in
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdoutPipe error: %v", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error running ffmpeg: %v", err)
+	}
+
+	return &ffmpegSource{
+		cmd:      cmd,
+		pcm:      bufio.NewReaderSize(out, 16384),
+		encoder:  encoder,
+		audiobuf: make([]int16, frameSize*channels)}, nil
+}
+
+func (s *ffmpegSource) readOpus() ([]byte, error) {
+	err := binary.Read(s.pcm, binary.LittleEndian, &s.audiobuf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading from ffmpeg stdout: %v", err)
+	}
+
+	opus, err := s.encoder.Encode(s.audiobuf, frameSize, maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding opus: %v", err)
+	}
+
+	return opus, nil
+}
+
+func (s *ffmpegSource) close() error {
+	s.closeOnce.Do(func() {
+		stopProcess(s.cmd)
+	})
+	return nil
+}
+
+// ytdlSource streams a video with yt-dlp, nothing is written to disk.
+// Opus audio is sent as is, other codecs are re-encoded with ffmpeg.
+type ytdlSource struct {
+	cmd       *exec.Cmd
+	audio     audioSource
+	closeOnce sync.Once
+}
+
+// newYtdlSource starts streaming the audio of a video id or url.
+func newYtdlSource(video string) (*ytdlSource, error) {
+	cmd := exec.Command("yt-dlp", "--quiet", "--no-playlist", "-f", "251/bestaudio", "-o", "-", "--", video)
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdoutPipe error: %v", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error running yt-dlp: %v", err)
+	}
+
+	audio, err := newPassthroughSource(out)
+	if err != nil {
+		stopProcess(cmd)
+		return nil, err
+	}
+
+	return &ytdlSource{cmd: cmd, audio: audio}, nil
+}
+
+func (s *ytdlSource) readOpus() ([]byte, error) {
+	return s.audio.readOpus()
+}
+
+// close kills yt-dlp before the audio is closed, ffmpeg can not be waited
+// for while it is still copying a stalled stream from yt-dlp.
+func (s *ytdlSource) close() error {
+	s.closeOnce.Do(func() {
+		s.cmd.Process.Kill()
+		s.audio.close()
+		s.cmd.Wait()
+	})
+	return nil
+}
+
+// lookupTrack finds a video with yt-dlp, query is either an url or
+// words to search youtube for.
+func lookupTrack(query string) (*track, error) {
+	target := query
+	if !strings.HasPrefix(query, "https://") && !strings.HasPrefix(query, "http://") {
+		target = "ytsearch1:" + query
+	}
+
+	out, err := exec.Command("yt-dlp", "--quiet", "--no-playlist", "--skip-download", "--dump-json", "--", target).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running yt-dlp: %v", err)
+	}
+
+	// a search without results gives no output
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, errNoResults
+	}
+
+	var info struct {
+		Title      string  `json:"title"`
+		Duration   float64 `json:"duration"`
+		WebpageURL string  `json:"webpage_url"`
+		Thumbnail  string  `json:"thumbnail"`
+	}
+	err = json.Unmarshal(out, &info)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing yt-dlp output: %v", err)
+	}
+
+	return &track{
+		title:     info.Title,
+		url:       info.WebpageURL,
+		duration:  time.Duration(info.Duration * float64(time.Second)),
+		thumbnail: info.Thumbnail}, nil
+}
+
+// webmSource sends the opus frames of a webm file without re-encoding
+type webmSource struct {
+	demuxer *webmReader
+	// first is the frame read to check the frame size
+	first []byte
+}
+
+func newWebmSource(in io.Reader) (*webmSource, error) {
+	demuxer, err := newWebmReader(in)
+	if err != nil {
+		return nil, err
+	}
+	if !demuxer.isOpus() {
+		return nil, fmt.Errorf("audio codec is %s", demuxer.audio.codecID)
+	}
+
+	first, err := demuxer.readPacket()
+	if err != nil {
+		return nil, fmt.Errorf("error reading first webm frame: %v", err)
+	}
+	err = checkFrameSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webmSource{demuxer, first}, nil
+}
+
+func (s *webmSource) readOpus() ([]byte, error) {
+	if s.first != nil {
+		frame := s.first
+		s.first = nil
+		return frame, nil
+	}
+
+	// a frame of another size would play at the wrong speed
+	frame, err := s.demuxer.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	return frame, checkFrameSize(frame)
+}
+
+func (s *webmSource) close() error {
+	return nil
+}
+
+// checkFrameSize tells if an opus packet can be passed through, the
+// voice connection sends every packet as a 20 ms frame.
+func checkFrameSize(p []byte) error {
+	samples, err := opusPacketSamples(p)
+	if err != nil {
+		return err
+	}
+	if samples != frameSize {
+		return fmt.Errorf("opus packets have %d samples, only %d can be passed through", samples, frameSize)
+	}
+	return nil
+}
+
+// newPassthroughSource passes the opus frames of webm and ogg audio straight
+// to the voice connection, other formats are re-encoded using ffmpeg.
+func newPassthroughSource(in io.Reader) (audioSource, error) {
+	br := bufio.NewReaderSize(in, 16384)
+	rec := &recordingReader{r: br}
+
+	var src audioSource
+	var err error
+	if magic, _ := br.Peek(4); string(magic) == "OggS" {
+		src, err = newOggStreamSource(rec)
+	} else {
+		src, err = newWebmSource(rec)
+	}
+	if err == nil {
+		rec.stop()
+		return src, nil
+	}
+
+	log.Printf("could not pass the audio through, re-encoding it instead: %v\n", err)
+
+	// ffmpeg needs the bytes already read by the demuxer as well
+	return newFFmpegSource(io.MultiReader(bytes.NewReader(rec.buf.Bytes()), br))
+}
+
+// fileSource plays a local file, see newPassthroughSource
+type fileSource struct {
+	file  *os.File
+	audio audioSource
+}
+
+// newFileSource opens a local file for playback.
+func newFileSource(path string) (*fileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audio file: %v", err)
+	}
+
+	audio, err := newPassthroughSource(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &fileSource{f, audio}, nil
+}
+
+func (s *fileSource) readOpus() ([]byte, error) {
+	return s.audio.readOpus()
+}
+
+func (s *fileSource) close() error {
+	s.audio.close()
+	return s.file.Close()
+}
+
+// recordingReader keeps a copy of everything read until stop is called
+type recordingReader struct {
+	r       io.Reader
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if !r.stopped {
+		r.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (r *recordingReader) stop() {
+	r.stopped = true
+	r.buf = bytes.Buffer{}
+}
+
+// stopProcess kills a process unless it has already exited and waits
+// for it so it does not linger as a zombie.
+func stopProcess(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// autocompleteDeadline leaves some of the 3 seconds Discord waits
+	// for the choices to sending them
+	autocompleteDeadline = time.Millisecond * 2500
+	maxChoices           = 25
+	maxChoiceLength      = 100
+)
+
+// autocompleteHandler returns the choices for the focused option of a command
+type autocompleteHandler func(i *interaction, focused *interactionOption) []commandChoice
+
+// handleAutocomplete sets the autocomplete handler of a registered command.
+func (r *commandRegistry) handleAutocomplete(name string, handler autocompleteHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.global[name]; ok {
+		c.autocomplete = handler
+	}
+	for _, cmds := range r.guilds {
+		if c, ok := cmds[name]; ok {
+			c.autocomplete = handler
+		}
+	}
+}
+
+// handleAutocompleteInteraction responds with the choices of the handler,
+// if the handler is too slow no choices are shown instead of an error.
+func (r *commandRegistry) handleAutocompleteInteraction(ctx *interactionContext) {
+	i := ctx.interaction
+
+	var handler autocompleteHandler
+	if c, ok := r.command(i.GuildID, i.Data.Name); ok {
+		r.mu.Lock()
+		handler = c.autocomplete
+		r.mu.Unlock()
+	}
+
+	var choices []commandChoice
+	focused, hasFocus := focusedOption(i.Data.Options)
+	if handler != nil && hasFocus {
+		result := make(chan []commandChoice, 1)
+		go func() {
+			result <- handler(i, focused)
+		}()
+
+		select {
+		case choices = <-result:
+		case <-time.After(autocompleteDeadline):
+			log.Printf("autocomplete for /%s timed out\n", i.Data.Name)
+		}
+	}
+
+	err := ctx.autocomplete(choices)
+	if err != nil {
+		log.Printf("error sending autocomplete choices: %v\n", err)
+	}
+}
+
+// focusedOption finds the option the user is typing in, it can be
+// in a sub command.
+func focusedOption(options []interactionOption) (*interactionOption, bool) {
+	for i := range options {
+		if options[i].Focused {
+			return &options[i], true
+		}
+		if o, ok := focusedOption(options[i].Options); ok {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+// autocomplete responds with up to 25 choices, choices with names or
+// values that are too long are shortened or left out.
+func (ctx *interactionContext) autocomplete(choices []commandChoice) error {
+	valid := make([]commandChoice, 0, maxChoices)
+	for _, c := range choices {
+		if len(valid) == maxChoices {
+			break
+		}
+		if s, ok := c.Value.(string); ok && len(s) > maxChoiceLength {
+			continue
+		}
+		c.Name = truncate(c.Name, maxChoiceLength)
+		valid = append(valid, c)
+	}
+
+	data := struct {
+		Choices []commandChoice `json:"choices"`
+	}{valid}
+
+	return ctx.sendResponse(&interactionResponse{Type: responseAutocomplete, Data: data})
+}
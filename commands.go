@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// queuePageSize is the number of tracks shown on a page of !queue
+const queuePageSize = 10
+
+// musicBot holds what the music commands need, the commands are run
+// by the router from both text messages and slash commands.
+type musicBot struct {
+	rest     *restClient
+	state    *state
+	voices   *voiceManager
+	players  *playerManager
+	registry *commandRegistry
+	router   *commandRouter
+
+	history *playHistory
+	index   *trackIndex
+	// suggestions are shown while typing the query of /play
+	suggestions suggestionProvider
+}
+
+// commandRequest tells where a command was used and by whom
+type commandRequest struct {
+	guildID   string
+	channelID string
+	userID    string
+	// member is the author as sent with the command, nil outside guilds
+	member *member
+}
+
+func newMusicBot(rest *restClient, s *state, voices *voiceManager, players *playerManager, registry *commandRegistry) *musicBot {
+	history := newPlayHistory()
+	index := newTrackIndex()
+
+	return &musicBot{
+		rest:        rest,
+		state:       s,
+		voices:      voices,
+		players:     players,
+		registry:    registry,
+		router:      newCommandRouter(rest, s, registry),
+		history:     history,
+		index:       index,
+		suggestions: suggestionChain{history, index}}
+}
+
+// registerCommands adds the music commands to the router.
+func (b *musicBot) registerCommands() {
+	b.router.add(&botCommand{
+		name:        "play",
+		aliases:     []string{"p"},
+		description: "Play a video or add it to the queue",
+		args: []commandArg{{
+			name:         "query",
+			description:  "Url or words to search for",
+			kind:         argText,
+			autocomplete: b.suggestQuery}},
+		// looking up the track often takes longer than 3 seconds
+		slow: true,
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			return b.play(r, args.text("query"))
+		}})
+
+	b.router.add(&botCommand{
+		name:        "skip",
+		aliases:     []string{"s", "next"},
+		description: "Skip the playing track",
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			return b.skip(r)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "stop",
+		description: "Clear the queue and leave the voice channel",
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			return b.stop(r)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "pause",
+		description: "Pause the playing track",
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			return b.pause(r)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "resume",
+		description: "Resume the paused track",
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			return b.resume(r)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "queue",
+		aliases:     []string{"q"},
+		description: "List the queued tracks",
+		args: []commandArg{{
+			name:        "page",
+			description: "Page of the queue to show",
+			kind:        argInt,
+			optional:    true,
+			min:         1}},
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			page, ok := args.int("page")
+			if !ok {
+				page = 1
+			}
+			return b.queue(r, page)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "np",
+		aliases:     []string{"nowplaying"},
+		description: "Show the playing track with buttons to control it",
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			return b.nowPlaying(r)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "remove",
+		aliases:     []string{"rm"},
+		description: "Remove a track from the queue",
+		args: []commandArg{{
+			name:        "position",
+			description: "Position of the track in the queue",
+			kind:        argInt,
+			min:         1}},
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			position, _ := args.int("position")
+			return b.remove(r, position)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "move",
+		aliases:     []string{"mv"},
+		description: "Move a track in the queue",
+		args: []commandArg{{
+			name:        "from",
+			description: "Position of the track to move",
+			kind:        argInt,
+			min:         1}, {
+			name:        "to",
+			description: "New position of the track",
+			kind:        argInt,
+			min:         1}},
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			from, _ := args.int("from")
+			to, _ := args.int("to")
+			return b.move(r, from, to)
+		}})
+
+	b.router.add(&botCommand{
+		name:        "clear",
+		description: "Remove all tracks from the queue",
+		run: func(r commandRequest, args commandArgs) *messageSend {
+			return b.clear(r)
+		}})
+}
+
+// suggestQuery suggests tracks for the query of /play, picking one
+// plays it by its url.
+func (b *musicBot) suggestQuery(i *interaction, focused *interactionOption) []commandChoice {
+	var query string
+	json.Unmarshal(focused.Value, &query)
+
+	var choices []commandChoice
+	for _, t := range b.suggestions.suggest(i.GuildID, query, maxChoices) {
+		name := fmt.Sprintf("%s (%s)", t.title, formatDuration(t.duration))
+		choices = append(choices, commandChoice{Name: name, Value: t.url})
+	}
+	return choices
+}
+
+// text is a reply with only text.
+func text(content string) *messageSend {
+	return &messageSend{Content: content}
+}
+
+func (b *musicBot) play(r commandRequest, query string) *messageSend {
+	cID, err := authorVoiceChannel(b.state, r.guildID, r.userID)
+	if err != nil {
+		return text(err.Error())
+	}
+
+	t, ok := b.index.get(query)
+	if !ok {
+		t, err = lookupTrack(query)
+		if errors.Is(err, errNoResults) {
+			return text(fmt.Sprintf("Found nothing for %q", query))
+		}
+		if err != nil {
+			log.Printf("error looking up %q: %v\n", query, err)
+			return text("Could not look up that track")
+		}
+		b.index.add(t)
+	}
+	t.requester = r.userID
+	b.history.record(r.guildID, t)
+
+	voi, err := connectVoice(b.voices, r.guildID, cID)
+	if err != nil {
+		log.Printf("error establishing voice connection: %v\n", err)
+		return text("Could not join your voice channel")
+	}
+
+	p := b.players.forGuild(r.guildID, voi)
+	_, _, playing := p.nowPlaying()
+	i := p.enqueue(t)
+
+	if !playing && i == 0 {
+		return b.newPlayerControls(r.guildID).message(t, 0, "")
+	}
+	return text(fmt.Sprintf("Queued **%s** (%s) at position %d", t.title, formatDuration(t.duration), i+1))
+}
+
+func (b *musicBot) skip(r commandRequest) *messageSend {
+	if err := checkListening(b.state, r.guildID, r.userID); err != nil {
+		return text(err.Error())
+	}
+
+	p, ok := b.players.get(r.guildID)
+	if !ok || !p.skip() {
+		return text("Nothing is playing")
+	}
+	return text("Skipped")
+}
+
+// stop clears the queue and leaves the voice channel.
+func (b *musicBot) stop(r commandRequest) *messageSend {
+	if err := b.leave(r); err != nil {
+		return text(err.Error())
+	}
+	return text("Stopped and cleared the queue")
+}
+
+// leave removes the player of the guild and leaves the voice channel, the
+// error tells the user why the bot did not stop.
+func (b *musicBot) leave(r commandRequest) error {
+	if _, ok := b.voices.get(r.guildID); !ok {
+		return errors.New("I am not in a voice channel")
+	}
+	if err := checkListening(b.state, r.guildID, r.userID); err != nil {
+		return err
+	}
+
+	b.players.remove(r.guildID)
+	err := b.voices.leave(r.guildID)
+	if err != nil {
+		log.Printf("error leaving voice: %v\n", err)
+	}
+	return nil
+}
+
+func (b *musicBot) pause(r commandRequest) *messageSend {
+	p, ok := b.players.get(r.guildID)
+	if !ok || !p.pause() {
+		return text("Nothing is playing")
+	}
+	return text("Paused")
+}
+
+func (b *musicBot) resume(r commandRequest) *messageSend {
+	p, ok := b.players.get(r.guildID)
+	if !ok || !p.resume() {
+		return text("Nothing is paused")
+	}
+	return text("Resumed")
+}
+
+// queue lists a page of the queued tracks, pages start at 1.
+func (b *musicBot) queue(r commandRequest, page int) *messageSend {
+	p, ok := b.players.get(r.guildID)
+	if !ok {
+		return text("The queue is empty")
+	}
+
+	tracks := p.queue.list()
+	current, _, playing := p.nowPlaying()
+	if len(tracks) == 0 && !playing {
+		return text("The queue is empty")
+	}
+
+	pages := (len(tracks) + queuePageSize - 1) / queuePageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page < 1 || page > pages {
+		return text(fmt.Sprintf("Page must be a number from 1 to %d", pages))
+	}
+
+	var sb strings.Builder
+	if playing {
+		fmt.Fprintf(&sb, "Now playing: **%s** (%s)\n", current.title, formatDuration(current.duration))
+	}
+
+	start := (page - 1) * queuePageSize
+	end := start + queuePageSize
+	if end > len(tracks) {
+		end = len(tracks)
+	}
+	for i, t := range tracks[start:end] {
+		fmt.Fprintf(&sb, "%d. %s (%s)\n", start+i+1, t.title, formatDuration(t.duration))
+	}
+
+	fmt.Fprintf(&sb, "Page %d/%d, %d tracks, %s in total", page, pages, len(tracks), formatDuration(p.queue.duration()))
+	return text(sb.String())
+}
+
+func (b *musicBot) nowPlaying(r commandRequest) *messageSend {
+	p, ok := b.players.get(r.guildID)
+	if !ok {
+		return text("Nothing is playing")
+	}
+
+	t, elapsed, playing := p.nowPlaying()
+	if !playing {
+		return text("Nothing is playing")
+	}
+
+	return b.newPlayerControls(r.guildID).message(t, elapsed, "")
+}
+
+// remove removes a track, positions start at 1 like in !queue.
+func (b *musicBot) remove(r commandRequest, position int) *messageSend {
+	p, ok := b.players.get(r.guildID)
+	if !ok {
+		return text("The queue is empty")
+	}
+
+	t, err := p.queue.remove(position - 1)
+	if err != nil {
+		return text(fmt.Sprintf("There is no track at position %d", position))
+	}
+	return text(fmt.Sprintf("Removed **%s**", t.title))
+}
+
+func (b *musicBot) move(r commandRequest, from, to int) *messageSend {
+	p, ok := b.players.get(r.guildID)
+	if !ok {
+		return text("The queue is empty")
+	}
+
+	err := p.queue.move(from-1, to-1)
+	if err != nil {
+		return text(fmt.Sprintf("Positions must be between 1 and %d", p.queue.len()))
+	}
+	return text(fmt.Sprintf("Moved track %d to position %d", from, to))
+}
+
+func (b *musicBot) clear(r commandRequest) *messageSend {
+	p, ok := b.players.get(r.guildID)
+	if !ok {
+		return text("The queue is empty")
+	}
+
+	n := p.queue.clear()
+	return text(fmt.Sprintf("Removed %d tracks from the queue", n))
+}
+
+// formatDuration formats d as m:ss or h:mm:ss.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	min := int(d/time.Minute) % 60
+	sec := int(d/time.Second) % 60
+
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, min, sec)
+	}
+	return fmt.Sprintf("%d:%02d", min, sec)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Component types
+const (
+	componentActionRow  = 1
+	componentButton     = 2
+	componentStringMenu = 3
+)
+
+// Button styles
+const (
+	buttonPrimary   = 1
+	buttonSecondary = 2
+	buttonSuccess   = 3
+	buttonDanger    = 4
+	buttonLink      = 5
+)
+
+// component is a button, select menu or an action row holding them
+type component struct {
+	Type        int            `json:"type"`
+	Style       int            `json:"style,omitempty"`
+	Label       string         `json:"label,omitempty"`
+	CustomID    string         `json:"custom_id,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Disabled    bool           `json:"disabled,omitempty"`
+	Placeholder string         `json:"placeholder,omitempty"`
+	Options     []selectOption `json:"options,omitempty"`
+	Components  []component    `json:"components,omitempty"`
+}
+
+type selectOption struct {
+	Label       string `json:"label"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	Default     bool   `json:"default,omitempty"`
+}
+
+// actionRow holds up to 5 buttons or a single select menu.
+func actionRow(components ...component) component {
+	return component{Type: componentActionRow, Components: components}
+}
+
+func button(style int, label, customID string) component {
+	return component{Type: componentButton, Style: style, Label: label, CustomID: customID}
+}
+
+// selectMenu lets the user pick one of up to 25 options.
+func selectMenu(customID, placeholder string, options []selectOption) component {
+	return component{
+		Type:        componentStringMenu,
+		CustomID:    customID,
+		Placeholder: placeholder,
+		Options:     options}
+}
+
+// componentHandler handles a click on a button or a selection in a menu
+type componentHandler func(ctx *interactionContext)
+
+type registeredComponent struct {
+	handler componentHandler
+	expires time.Time
+}
+
+// componentIDs makes custom ids unique to the message they are sent with
+var componentIDs uint64
+
+// newComponentID returns a custom id that is not used by any other
+// component, name makes it readable.
+func newComponentID(name string) string {
+	return name + ":" + strconv.FormatUint(atomic.AddUint64(&componentIDs, 1), 36)
+}
+
+// handleComponent registers the handler of a component, the handler is
+// forgotten after ttl and the component stops working.
+func (r *commandRegistry) handleComponent(customID string, ttl time.Duration, handler componentHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// forget the expired handlers while we are here
+	now := time.Now()
+	for id, c := range r.components {
+		if now.After(c.expires) {
+			delete(r.components, id)
+		}
+	}
+
+	r.components[customID] = &registeredComponent{handler, now.Add(ttl)}
+}
+
+// removeComponent forgets the handler of a component.
+func (r *commandRegistry) removeComponent(customID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.components, customID)
+}
+
+// handleComponentInteraction routes a component interaction by its custom id.
+func (r *commandRegistry) handleComponentInteraction(ctx *interactionContext) {
+	customID := ctx.interaction.Data.CustomID
+
+	r.mu.Lock()
+	c, ok := r.components[customID]
+	if ok && time.Now().After(c.expires) {
+		delete(r.components, customID)
+		ok = false
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		err := ctx.reply(&messageSend{Content: "These controls have expired", Flags: messageFlagEphemeral})
+		if err != nil {
+			log.Printf("error replying to expired component: %v\n", err)
+		}
+		return
+	}
+
+	c.handler(ctx)
+}
+
+// update responds to a component by editing the message it is on.
+func (ctx *interactionContext) update(send *messageSend) error {
+	return ctx.sendResponse(&interactionResponse{Type: responseUpdateMessage, Data: send})
+}
+
+// deferUpdate acknowledges a component without changing its message yet.
+func (ctx *interactionContext) deferUpdate() error {
+	return ctx.sendResponse(&interactionResponse{Type: responseDeferredUpdateMessage})
+}
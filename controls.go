@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// playerControlsTTL is how long the buttons of a now playing message work
+const playerControlsTTL = time.Minute * 30
+
+// playerControls are the buttons and the select menu on a now playing
+// message. The custom ids are kept when the message is updated, so the
+// handlers are registered once for every message.
+type playerControls struct {
+	bot     *musicBot
+	guildID string
+
+	pauseID string
+	skipID  string
+	stopID  string
+	loopID  string
+	jumpID  string
+}
+
+func (b *musicBot) newPlayerControls(guildID string) *playerControls {
+	c := playerControls{
+		bot:     b,
+		guildID: guildID,
+		pauseID: newComponentID("pause"),
+		skipID:  newComponentID("skip"),
+		stopID:  newComponentID("stop"),
+		loopID:  newComponentID("loop"),
+		jumpID:  newComponentID("jump")}
+
+	b.registry.handleComponent(c.pauseID, playerControlsTTL, c.pause)
+	b.registry.handleComponent(c.skipID, playerControlsTTL, c.skip)
+	b.registry.handleComponent(c.stopID, playerControlsTTL, c.stop)
+	b.registry.handleComponent(c.loopID, playerControlsTTL, c.loop)
+	b.registry.handleComponent(c.jumpID, playerControlsTTL, c.jump)
+	return &c
+}
+
+// message shows a track with the controls, status is shown below the
+// track. Without a track the message tells that nothing is playing.
+func (c *playerControls) message(t *track, elapsed time.Duration, status string) *messageSend {
+	// the message is updated by replacing the embed, so there is always one
+	e := embed{Title: "Nothing is playing"}
+	if t != nil {
+		e = nowPlayingEmbed(t, elapsed)
+	}
+	if status != "" {
+		e.Footer = &embedFooter{Text: status}
+	}
+
+	return &messageSend{
+		Embeds:          []embed{e},
+		Components:      c.components(),
+		AllowedMentions: &allowedMentions{Parse: []string{}}}
+}
+
+// components renders the controls for the current state of the player.
+func (c *playerControls) components() []component {
+	var playing, paused, looping bool
+	var queued []*track
+
+	p, ok := c.bot.players.get(c.guildID)
+	if ok {
+		_, _, playing = p.nowPlaying()
+		paused = p.isPaused()
+		looping = p.looping()
+		queued = p.queue.list()
+	}
+
+	pause := button(buttonSecondary, "Pause", c.pauseID)
+	if paused {
+		pause = button(buttonPrimary, "Resume", c.pauseID)
+	}
+	loop := button(buttonSecondary, "Loop", c.loopID)
+	if looping {
+		loop = button(buttonSuccess, "Looping", c.loopID)
+	}
+	skip := button(buttonSecondary, "Skip", c.skipID)
+	stop := button(buttonDanger, "Stop", c.stopID)
+
+	pause.Disabled = !playing
+	skip.Disabled = !playing
+	loop.Disabled = !playing
+	stop.Disabled = !ok
+
+	rows := []component{actionRow(pause, skip, stop, loop)}
+
+	// the values are urls as the indexes change when the queue does, they
+	// have to be unique and at most 100 characters
+	var options []selectOption
+	seen := make(map[string]bool)
+	for i, t := range queued {
+		if len(options) == 25 {
+			break
+		}
+		if seen[t.url] || len(t.url) > 100 {
+			continue
+		}
+		seen[t.url] = true
+		options = append(options, selectOption{
+			Label:       truncate(fmt.Sprintf("%d. %s", i+1, t.title), 100),
+			Value:       t.url,
+			Description: formatDuration(t.duration)})
+	}
+
+	// a select menu needs at least one and at most 25 options
+	if len(options) > 0 {
+		rows = append(rows, actionRow(selectMenu(c.jumpID, "Jump to a queued track", options)))
+	}
+
+	return rows
+}
+
+// current returns the message for the track that is playing now.
+func (c *playerControls) current(status string) *messageSend {
+	p, ok := c.bot.players.get(c.guildID)
+	if !ok {
+		return c.message(nil, 0, status)
+	}
+
+	t, elapsed, _ := p.nowPlaying()
+	return c.message(t, elapsed, status)
+}
+
+func (c *playerControls) pause(ctx *interactionContext) {
+	user := ctx.interaction.author()
+
+	p, ok := c.bot.players.get(c.guildID)
+	switch {
+	case ok && p.pause():
+		c.update(ctx, c.current("Paused by "+user.Username))
+	case ok && p.resume():
+		c.update(ctx, c.current("Resumed by "+user.Username))
+	default:
+		c.update(ctx, c.current(""))
+	}
+}
+
+// skip shows the next track right away, as the player needs some time
+// before it starts playing it.
+func (c *playerControls) skip(ctx *interactionContext) {
+	if c.notListening(ctx) {
+		return
+	}
+
+	p, ok := c.bot.players.get(c.guildID)
+	if !ok || !p.skip() {
+		c.update(ctx, c.current(""))
+		return
+	}
+
+	status := "Skipped by " + ctx.interaction.author().Username
+	next, ok := p.queue.peek()
+	if !ok {
+		c.update(ctx, c.message(nil, 0, status))
+		return
+	}
+	c.update(ctx, c.message(next, 0, status))
+}
+
+// stop leaves voice, the controls stop working after that.
+func (c *playerControls) stop(ctx *interactionContext) {
+	if c.notListening(ctx) {
+		return
+	}
+
+	// the controls keep working when the bot did not stop
+	err := c.bot.leave(ctx.interaction.request())
+	if err != nil {
+		err = ctx.reply(&messageSend{Content: err.Error(), Flags: messageFlagEphemeral})
+		if err != nil {
+			log.Printf("error replying to player controls: %v\n", err)
+		}
+		return
+	}
+
+	for _, id := range []string{c.pauseID, c.skipID, c.stopID, c.loopID, c.jumpID} {
+		c.bot.registry.removeComponent(id)
+	}
+
+	c.update(ctx, c.message(nil, 0, "Stopped by "+ctx.interaction.author().Username))
+}
+
+func (c *playerControls) loop(ctx *interactionContext) {
+	p, ok := c.bot.players.get(c.guildID)
+	if !ok {
+		c.update(ctx, c.current(""))
+		return
+	}
+
+	p.setLoop(!p.looping())
+	c.update(ctx, c.current(""))
+}
+
+// jump plays the track picked in the select menu.
+func (c *playerControls) jump(ctx *interactionContext) {
+	values := ctx.interaction.Data.Values
+	p, ok := c.bot.players.get(c.guildID)
+	if !ok || len(values) != 1 {
+		c.update(ctx, c.current(""))
+		return
+	}
+
+	if c.notListening(ctx) {
+		return
+	}
+
+	t, err := p.jump(values[0])
+	if err != nil {
+		// the queue changed after the menu was sent
+		c.update(ctx, c.current("That track is no longer in the queue"))
+		return
+	}
+
+	c.update(ctx, c.message(t, 0, "Picked from the queue by "+ctx.interaction.author().Username))
+}
+
+// notListening tells the user when they are not in the voice channel of
+// the bot, the controls are left as they are.
+func (c *playerControls) notListening(ctx *interactionContext) bool {
+	err := checkListening(c.bot.state, c.guildID, ctx.interaction.author().ID)
+	if err == nil {
+		return false
+	}
+
+	err = ctx.reply(&messageSend{Content: err.Error(), Flags: messageFlagEphemeral})
+	if err != nil {
+		log.Printf("error replying to player controls: %v\n", err)
+	}
+	return true
+}
+
+func (c *playerControls) update(ctx *interactionContext, send *messageSend) {
+	err := ctx.update(send)
+	if err != nil {
+		log.Printf("error updating player controls: %v\n", err)
+	}
+}
+
+// truncate shortens s to at most n characters.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
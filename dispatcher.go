@@ -0,0 +1,239 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// dispatcher delivers gateway events to every handler registered for
+// them. Handlers run concurrently unless the dispatcher is ordered, then
+// events are handled one at a time in the order they were received.
+// Sync handlers always run in order on the goroutine reading the events.
+type dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[string][]*eventHandler
+	nextID   int
+	ordered  bool
+	queue    chan dispatchedEvent
+}
+
+type eventHandler struct {
+	id   int
+	sync bool
+	fn   func(eventData)
+}
+
+type dispatchedEvent struct {
+	name string
+	data eventData
+}
+
+func newDispatcher(ordered bool) *dispatcher {
+	d := dispatcher{
+		handlers: make(map[string][]*eventHandler),
+		ordered:  ordered}
+
+	if ordered {
+		d.queue = make(chan dispatchedEvent, 256)
+		go d.runQueue()
+	}
+
+	return &d
+}
+
+// addHandler registers a handler for the raw event data and returns
+// a function that removes the handler again.
+func (d *dispatcher) addHandler(event string, fn func(eventData)) func() {
+	return d.add(event, false, fn)
+}
+
+// addSyncHandler registers a handler that sees events in the order they
+// were received, it blocks the gateway so it has to return quickly.
+func (d *dispatcher) addSyncHandler(event string, fn func(eventData)) func() {
+	return d.add(event, true, fn)
+}
+
+func (d *dispatcher) add(event string, inOrder bool, fn func(eventData)) func() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	h := &eventHandler{d.nextID, inOrder, fn}
+	d.handlers[event] = append(d.handlers[event], h)
+
+	return func() {
+		d.removeHandler(event, h.id)
+	}
+}
+
+func (d *dispatcher) removeHandler(event string, id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	handlers := d.handlers[event]
+	for i, h := range handlers {
+		if h.id == id {
+			// copy so dispatches in progress keep their own slice
+			d.handlers[event] = append(handlers[:i:i], handlers[i+1:]...)
+			break
+		}
+	}
+
+	if len(d.handlers[event]) == 0 {
+		delete(d.handlers, event)
+	}
+}
+
+// events returns the names of all events with at least one handler.
+func (d *dispatcher) events() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	names := make([]string, 0, len(d.handlers))
+	for name := range d.handlers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// dispatch passes the event to its handlers.
+func (d *dispatcher) dispatch(event string, data eventData) {
+	if d.ordered {
+		d.queue <- dispatchedEvent{event, data}
+		return
+	}
+
+	d.mu.RLock()
+	handlers := d.handlers[event]
+	d.mu.RUnlock()
+
+	for _, h := range handlers {
+		if h.sync {
+			d.call(event, h, data)
+		}
+	}
+
+	for _, h := range handlers {
+		if !h.sync {
+			go d.call(event, h, data)
+		}
+	}
+}
+
+func (d *dispatcher) runQueue() {
+	for e := range d.queue {
+		d.mu.RLock()
+		handlers := d.handlers[e.name]
+		d.mu.RUnlock()
+
+		for _, h := range handlers {
+			d.call(e.name, h, e.data)
+		}
+	}
+}
+
+// call runs a handler, a panicking handler is logged
+// instead of taking down the whole bot.
+func (d *dispatcher) call(event string, h *eventHandler, data eventData) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("recovered from panic in %s handler: %v\n%s", event, r, debug.Stack())
+		}
+	}()
+
+	h.fn(data)
+}
+
+// onConnect registers a handler for when a session is ready or resumed.
+func (d *dispatcher) onConnect(fn func()) func() {
+	return d.addHandler(connectEvent, func(eventData) {
+		fn()
+	})
+}
+
+// onDisconnect registers a handler for when the gateway connection is lost.
+func (d *dispatcher) onDisconnect(fn func()) func() {
+	return d.addHandler(disconnectEvent, func(eventData) {
+		fn()
+	})
+}
+
+// onReconnectFailed registers a handler for when the gateway gives up reconnecting.
+func (d *dispatcher) onReconnectFailed(fn func()) func() {
+	return d.addHandler(reconnectFailedEvent, func(eventData) {
+		fn()
+	})
+}
+
+func (d *dispatcher) onReady(fn func(*ready)) func() {
+	return d.addHandler(readyEvent, func(data eventData) {
+		var r ready
+		if decodeEvent(readyEvent, data, &r) {
+			fn(&r)
+		}
+	})
+}
+
+func (d *dispatcher) onMessageCreate(fn func(*message)) func() {
+	return d.addHandler(messageCreateEvent, func(data eventData) {
+		var m message
+		if decodeEvent(messageCreateEvent, data, &m) {
+			fn(&m)
+		}
+	})
+}
+
+func (d *dispatcher) onInteractionCreate(fn func(*interaction)) func() {
+	return d.addHandler(interactionCreateEvent, func(data eventData) {
+		var i interaction
+		if decodeEvent(interactionCreateEvent, data, &i) {
+			fn(&i)
+		}
+	})
+}
+
+func (d *dispatcher) onGuildCreate(fn func(*guild)) func() {
+	return d.addHandler(guildCreateEvent, func(data eventData) {
+		var g guild
+		if decodeEvent(guildCreateEvent, data, &g) {
+			fn(&g)
+		}
+	})
+}
+
+func (d *dispatcher) onGuildUpdate(fn func(*guild)) func() {
+	return d.addHandler(guildUpdateEvent, func(data eventData) {
+		var g guild
+		if decodeEvent(guildUpdateEvent, data, &g) {
+			fn(&g)
+		}
+	})
+}
+
+func (d *dispatcher) onVoiceStateUpdate(fn func(*voiceStateUpdateResponse)) func() {
+	return d.addHandler(voiceStateUpdateEvent, func(data eventData) {
+		var vs voiceStateUpdateResponse
+		if decodeEvent(voiceStateUpdateEvent, data, &vs) {
+			fn(&vs)
+		}
+	})
+}
+
+func (d *dispatcher) onVoiceServerUpdate(fn func(*voiceServerUpdate)) func() {
+	return d.addHandler(voiceServerUpdateEvent, func(data eventData) {
+		var vs voiceServerUpdate
+		if decodeEvent(voiceServerUpdateEvent, data, &vs) {
+			fn(&vs)
+		}
+	})
+}
+
+func decodeEvent(event string, data eventData, v interface{}) bool {
+	err := data.decode(v)
+	if err != nil {
+		log.Printf("error unmarshalling %s: %v\n", event, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func jsonData(s string) eventData {
+	return eventData{raw: []byte(s)}
+}
+
+// waitFor fails the test if c does not receive within a second.
+func waitFor(t *testing.T, c chan string) string {
+	t.Helper()
+
+	select {
+	case s := <-c:
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("handler did not run")
+		return ""
+	}
+}
+
+func TestDispatcherHandlers(t *testing.T) {
+	d := newDispatcher(false)
+	got := make(chan string, 10)
+
+	d.onMessageCreate(func(m *message) { got <- "first " + m.Content })
+	removeSecond := d.onMessageCreate(func(m *message) { got <- "second " + m.Content })
+	d.onReady(func(*ready) { got <- "ready" })
+
+	d.dispatch(messageCreateEvent, jsonData(`{"content": "hi"}`))
+	results := []string{waitFor(t, got), waitFor(t, got)}
+	sort.Strings(results)
+	if results[0] != "first hi" || results[1] != "second hi" {
+		t.Errorf("got %v, want both handlers", results)
+	}
+
+	removeSecond()
+	d.dispatch(messageCreateEvent, jsonData(`{"content": "again"}`))
+	if s := waitFor(t, got); s != "first again" {
+		t.Errorf("got %q, want only the first handler", s)
+	}
+
+	// invalid data is logged instead of calling the handler
+	d.dispatch(messageCreateEvent, jsonData(`{"content": 5}`))
+	select {
+	case s := <-got:
+		t.Errorf("handler got %q for invalid data", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDispatcherEvents(t *testing.T) {
+	d := newDispatcher(false)
+	removeReady := d.onReady(func(*ready) {})
+	removeMessage := d.onMessageCreate(func(*message) {})
+	removeMessage2 := d.onMessageCreate(func(*message) {})
+
+	events := d.events()
+	sort.Strings(events)
+	if len(events) != 2 || events[0] != messageCreateEvent || events[1] != readyEvent {
+		t.Errorf("got events %v", events)
+	}
+
+	removeReady()
+	removeMessage()
+	if events := d.events(); len(events) != 1 || events[0] != messageCreateEvent {
+		t.Errorf("got events %v after removing ready, want only %s", events, messageCreateEvent)
+	}
+
+	removeMessage2()
+	removeMessage2()
+	if events := d.events(); len(events) != 0 {
+		t.Errorf("got events %v after removing every handler", events)
+	}
+}
+
+func TestDispatcherSyncHandlers(t *testing.T) {
+	d := newDispatcher(false)
+
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+
+	release := make(chan struct{})
+	done := make(chan string, 1)
+	d.addHandler("TEST", func(eventData) {
+		<-release
+		done <- "async"
+	})
+	d.addSyncHandler("TEST", func(eventData) { record("sync 1") })
+	d.addSyncHandler("TEST", func(eventData) { record("sync 2") })
+
+	// sync handlers have run when dispatch returns, in the order they were
+	// added, while the async handler is still blocked
+	d.dispatch("TEST", eventData{})
+	mu.Lock()
+	if len(order) != 2 || order[0] != "sync 1" || order[1] != "sync 2" {
+		t.Errorf("got %v, want the sync handlers in order", order)
+	}
+	mu.Unlock()
+
+	close(release)
+	waitFor(t, done)
+}
+
+func TestDispatcherOrdered(t *testing.T) {
+	d := newDispatcher(true)
+	got := make(chan string, 100)
+
+	d.addHandler("TEST", func(data eventData) {
+		var n int
+		data.decode(&n)
+		// a slow handler would let later events overtake it if they ran
+		// concurrently
+		if n%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		got <- strconv.Itoa(n)
+	})
+
+	for i := 0; i < 50; i++ {
+		d.dispatch("TEST", jsonData(strconv.Itoa(i)))
+	}
+	for i := 0; i < 50; i++ {
+		if s := waitFor(t, got); s != strconv.Itoa(i) {
+			t.Fatalf("got event %s, want %d", s, i)
+		}
+	}
+}
+
+func TestDispatcherPanic(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		d := newDispatcher(ordered)
+		got := make(chan string, 10)
+
+		d.addSyncHandler("TEST", func(eventData) { panic("sync handler") })
+		d.addHandler("TEST", func(eventData) { panic("handler") })
+		d.addSyncHandler("TEST", func(eventData) { got <- "sync" })
+		d.addHandler("TEST", func(eventData) { got <- "async" })
+
+		d.dispatch("TEST", eventData{})
+		results := []string{waitFor(t, got), waitFor(t, got)}
+		sort.Strings(results)
+		if results[0] != "async" || results[1] != "sync" {
+			t.Errorf("ordered %v: got %v, want the handlers after the panics to run", ordered, results)
+		}
+
+		// the dispatcher keeps working after a panic
+		d.dispatch("TEST", eventData{})
+		waitFor(t, got)
+		waitFor(t, got)
+	}
+}
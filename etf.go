@@ -0,0 +1,842 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// External Term Format tags used by Discord
+const (
+	etfVersion          = 131
+	etfNewFloat         = 70
+	etfCompressed       = 80
+	etfSmallInteger     = 97
+	etfInteger          = 98
+	etfFloat            = 99
+	etfAtom             = 100
+	etfSmallTuple       = 104
+	etfLargeTuple       = 105
+	etfNil              = 106
+	etfString           = 107
+	etfList             = 108
+	etfBinary           = 109
+	etfSmallBig         = 110
+	etfLargeBig         = 111
+	etfSmallAtom        = 115
+	etfMap              = 116
+	etfAtomUTF8         = 118
+	etfSmallAtomUTF8    = 119
+	etfMaxSmallBigBytes = 255
+)
+
+// decodeETF decodes a term in the Erlang External Term Format into the
+// same kind of values encoding/json uses for interface{}: maps, slices,
+// strings, bools, nil, int64 and float64.
+//
+// Discord sends snowflakes as big integers over ETF, these are decoded
+// as strings since every id is a string in the json encoding.
+func decodeETF(b []byte) (interface{}, error) {
+	if len(b) == 0 || b[0] != etfVersion {
+		return nil, errors.New("etf data is missing the version byte")
+	}
+
+	d := etfDecoder{data: b[1:]}
+	v, err := d.term()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.data) != 0 {
+		return nil, fmt.Errorf("%d bytes left after etf term", len(d.data))
+	}
+
+	return v, nil
+}
+
+type etfDecoder struct {
+	data []byte
+}
+
+func (d *etfDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data) < n {
+		return nil, errors.New("unexpected end of etf data")
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *etfDecoder) uint8() (int, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return int(b[0]), nil
+}
+
+func (d *etfDecoder) uint16() (int, error) {
+	b, err := d.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(b)), nil
+}
+
+func (d *etfDecoder) uint32() (int, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(b)), nil
+}
+
+// term decodes the next term
+func (d *etfDecoder) term() (interface{}, error) {
+	tag, err := d.uint8()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case etfSmallInteger:
+		n, err := d.uint8()
+		return int64(n), err
+
+	case etfInteger:
+		n, err := d.uint32()
+		return int64(int32(n)), err
+
+	case etfNewFloat:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+
+	case etfFloat:
+		b, err := d.read(31)
+		if err != nil {
+			return nil, err
+		}
+		f, err := strconv.ParseFloat(string(bytes.TrimRight(b, "\x00")), 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing etf float: %v", err)
+		}
+		return f, nil
+
+	case etfAtom, etfAtomUTF8:
+		n, err := d.uint16()
+		if err != nil {
+			return nil, err
+		}
+		return d.atom(n)
+
+	case etfSmallAtom, etfSmallAtomUTF8:
+		n, err := d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		return d.atom(n)
+
+	case etfSmallTuple:
+		n, err := d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		return d.list(n)
+
+	case etfLargeTuple:
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return d.list(n)
+
+	case etfNil:
+		return []interface{}{}, nil
+
+	case etfString, etfBinary:
+		var n int
+		if tag == etfString {
+			n, err = d.uint16()
+		} else {
+			n, err = d.uint32()
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+
+	case etfList:
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		l, err := d.list(n)
+		if err != nil {
+			return nil, err
+		}
+
+		// proper lists end with an empty list as tail
+		tail, err := d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		if tail != etfNil {
+			return nil, errors.New("improper etf lists are not supported")
+		}
+		return l, nil
+
+	case etfSmallBig:
+		n, err := d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		return d.big(n)
+
+	case etfLargeBig:
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return d.big(n)
+
+	case etfMap:
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return d.object(n)
+
+	case etfCompressed:
+		size, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		r, err := zlib.NewReader(bytes.NewReader(d.data))
+		if err != nil {
+			return nil, fmt.Errorf("error reading compressed etf term: %v", err)
+		}
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("error inflating etf term: %v", err)
+		}
+		if len(b) != size {
+			return nil, errors.New("compressed etf term has the wrong size")
+		}
+
+		d.data = nil
+		inner := etfDecoder{data: b}
+		return inner.term()
+	}
+
+	return nil, fmt.Errorf("unsupported etf tag %d", tag)
+}
+
+func (d *etfDecoder) atom(n int) (interface{}, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+
+	switch string(b) {
+	case "nil", "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return string(b), nil
+}
+
+func (d *etfDecoder) list(n int) ([]interface{}, error) {
+	l := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.term()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	return l, nil
+}
+
+func (d *etfDecoder) object(n int) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.term()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.term()
+		if err != nil {
+			return nil, err
+		}
+
+		// keys are binaries or atoms, numbers are used as strings
+		// the way they would be in JSON
+		switch k := key.(type) {
+		case string:
+			m[k] = v
+		case int64:
+			m[strconv.FormatInt(k, 10)] = v
+		default:
+			return nil, fmt.Errorf("unsupported etf map key %T", key)
+		}
+	}
+	return m, nil
+}
+
+func (d *etfDecoder) big(n int) (interface{}, error) {
+	sign, err := d.uint8()
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+
+	// the digits are stored little endian
+	digits := make([]byte, n)
+	for i := range b {
+		digits[n-1-i] = b[i]
+	}
+
+	v := new(big.Int).SetBytes(digits)
+	if sign == 1 {
+		v.Neg(v)
+	}
+
+	// big integers are snowflakes, which are strings in JSON
+	return v.String(), nil
+}
+
+var (
+	eventDataType = reflect.TypeOf(eventData{})
+	numberType    = reflect.TypeOf(json.Number(""))
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// unmarshalTerm stores a term returned by decodeETF in the value pointed
+// to by v. Struct fields are matched by their json tags, so the same
+// types are used for both encodings.
+func unmarshalTerm(term interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("can not unmarshal etf into %T", v)
+	}
+	return setTerm(term, rv.Elem())
+}
+
+func setTerm(term interface{}, v reflect.Value) error {
+	if v.Type() == eventDataType {
+		v.Set(reflect.ValueOf(eventData{term: term, etf: true}))
+		return nil
+	}
+
+	// types with their own JSON decoding, such as time.Time and
+	// json.RawMessage, get the term as JSON
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		if u, ok := v.Addr().Interface().(json.Unmarshaler); ok {
+			b, err := json.Marshal(term)
+			if err != nil {
+				return err
+			}
+			return u.UnmarshalJSON(b)
+		}
+	}
+
+	if term == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setTerm(term, v.Elem())
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			break
+		}
+		v.Set(reflect.ValueOf(term))
+		return nil
+
+	case reflect.String:
+		switch t := term.(type) {
+		case string:
+			v.SetString(t)
+			return nil
+		case int64:
+			v.SetString(strconv.FormatInt(t, 10))
+			return nil
+		}
+
+	case reflect.Bool:
+		if b, ok := term.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := termInt(term)
+		if ok && !v.OverflowInt(n) {
+			v.SetInt(n)
+			return nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := termInt(term)
+		if ok && n >= 0 && !v.OverflowUint(uint64(n)) {
+			v.SetUint(uint64(n))
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		switch t := term.(type) {
+		case float64:
+			v.SetFloat(t)
+			return nil
+		case int64:
+			v.SetFloat(float64(t))
+			return nil
+		}
+
+	case reflect.Slice:
+		l, ok := term.([]interface{})
+		if !ok {
+			break
+		}
+		s := reflect.MakeSlice(v.Type(), len(l), len(l))
+		for i, e := range l {
+			err := setTerm(e, s.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+
+	case reflect.Array:
+		l, ok := term.([]interface{})
+		if !ok || len(l) != v.Len() {
+			break
+		}
+		for i, e := range l {
+			err := setTerm(e, v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		m, ok := term.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		out := reflect.MakeMapWithSize(v.Type(), len(m))
+		for k, e := range m {
+			ev := reflect.New(v.Type().Elem()).Elem()
+			err := setTerm(e, ev)
+			if err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), ev)
+		}
+		v.Set(out)
+		return nil
+
+	case reflect.Struct:
+		m, ok := term.(map[string]interface{})
+		if !ok {
+			break
+		}
+		fields := structFields(v.Type())
+		for k, e := range m {
+			f, ok := fields.lookup(k)
+			if !ok {
+				continue
+			}
+			err := setTerm(e, fieldByIndex(v, f.index))
+			if err != nil {
+				return fmt.Errorf("error decoding %s: %v", k, err)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("can not decode etf %T into %s", term, v.Type())
+}
+
+// termInt returns a whole number term, snowflakes are accepted as
+// numbers since they are decoded as strings.
+func termInt(term interface{}) (int64, bool) {
+	switch t := term.(type) {
+	case int64:
+		return t, true
+	case float64:
+		if t == math.Trunc(t) {
+			return int64(t), true
+		}
+	case string:
+		n, err := strconv.ParseInt(t, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex, but it allocates embedded
+// struct pointers on the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// etfField is a struct field with the name it has in a payload
+type etfField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+type etfFields []etfField
+
+// lookup finds a field the way encoding/json does, the exact name is
+// preferred over a name with a different case.
+func (fs etfFields) lookup(name string) (etfField, bool) {
+	for _, f := range fs {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fs {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return etfField{}, false
+}
+
+var fieldCache sync.Map
+
+// structFields returns the fields of a struct type by their json names,
+// the fields of embedded structs without a tag are included.
+func structFields(t reflect.Type) etfFields {
+	if fs, ok := fieldCache.Load(t); ok {
+		return fs.(etfFields)
+	}
+
+	var fs etfFields
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i != -1 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for _, f := range structFields(ft) {
+				f.index = append([]int{i}, f.index...)
+				fs = append(fs, f)
+			}
+			continue
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fs = append(fs, etfField{name, []int{i}, opts == "omitempty"})
+	}
+
+	fieldCache.Store(t, fs)
+	return fs
+}
+
+// marshalETF encodes v in the Erlang External Term Format the way
+// encoding/json would encode it, strings are encoded as binaries and
+// structs as maps with binary keys.
+func marshalETF(v interface{}) ([]byte, error) {
+	out := bytes.NewBuffer([]byte{etfVersion})
+	err := encodeETF(out, reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func encodeETF(out *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		writeETFAtom(out, "nil")
+		return nil
+	}
+
+	switch v.Type() {
+	case numberType:
+		return writeETFNumber(out, json.Number(v.String()))
+	case eventDataType:
+		d := v.Interface().(eventData)
+		if d.etf {
+			return encodeETF(out, reflect.ValueOf(d.term))
+		}
+		return encodeRawJSON(out, d.raw)
+	}
+
+	// types with their own JSON encoding, such as time.Time and
+	// json.RawMessage, are encoded from their JSON
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface && v.Type().Implements(marshalerType) {
+		b, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return err
+		}
+		return encodeRawJSON(out, b)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			writeETFAtom(out, "nil")
+			return nil
+		}
+		return encodeETF(out, v.Elem())
+
+	case reflect.Bool:
+		writeETFAtom(out, strconv.FormatBool(v.Bool()))
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeETFInt(out, big.NewInt(v.Int()))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		writeETFInt(out, new(big.Int).SetUint64(v.Uint()))
+
+	case reflect.Float32, reflect.Float64:
+		out.WriteByte(etfNewFloat)
+		binary.Write(out, binary.BigEndian, math.Float64bits(v.Float()))
+
+	case reflect.String:
+		out.WriteByte(etfBinary)
+		binary.Write(out, binary.BigEndian, uint32(v.Len()))
+		out.WriteString(v.String())
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			writeETFAtom(out, "nil")
+			return nil
+		}
+		if v.Len() == 0 {
+			out.WriteByte(etfNil)
+			return nil
+		}
+
+		out.WriteByte(etfList)
+		binary.Write(out, binary.BigEndian, uint32(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			err := encodeETF(out, v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		out.WriteByte(etfNil)
+
+	case reflect.Map:
+		if v.IsNil() {
+			writeETFAtom(out, "nil")
+			return nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("can not encode %s as etf", v.Type())
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		out.WriteByte(etfMap)
+		binary.Write(out, binary.BigEndian, uint32(len(keys)))
+		for _, k := range keys {
+			encodeETF(out, reflect.ValueOf(k.String()))
+			err := encodeETF(out, v.MapIndex(k))
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		var fields []etfField
+		for _, f := range structFields(v.Type()) {
+			fv, ok := fieldValue(v, f.index)
+			if !ok || f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			fields = append(fields, f)
+		}
+
+		out.WriteByte(etfMap)
+		binary.Write(out, binary.BigEndian, uint32(len(fields)))
+		for _, f := range fields {
+			fv, _ := fieldValue(v, f.index)
+			encodeETF(out, reflect.ValueOf(f.name))
+			err := encodeETF(out, fv)
+			if err != nil {
+				return fmt.Errorf("error encoding %s: %v", f.name, err)
+			}
+		}
+
+	default:
+		return fmt.Errorf("can not encode %s as etf", v.Type())
+	}
+
+	return nil
+}
+
+// encodeRawJSON encodes JSON that was already marshalled, numbers are
+// kept as they are instead of becoming floats.
+func encodeRawJSON(out *bytes.Buffer, data []byte) error {
+	if len(data) == 0 {
+		writeETFAtom(out, "nil")
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return fmt.Errorf("error decoding json for etf: %v", err)
+	}
+	return encodeETF(out, reflect.ValueOf(v))
+}
+
+// fieldValue returns a field of a struct, ok is false when the field is
+// in an embedded struct pointer that is nil.
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue tells if omitempty leaves out a value, like in encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func writeETFAtom(out *bytes.Buffer, a string) {
+	out.WriteByte(etfSmallAtomUTF8)
+	out.WriteByte(byte(len(a)))
+	out.WriteString(a)
+}
+
+func writeETFNumber(out *bytes.Buffer, n json.Number) error {
+	if b, ok := new(big.Int).SetString(string(n), 10); ok {
+		return writeETFInt(out, b)
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("error encoding etf number: %v", err)
+	}
+	out.WriteByte(etfNewFloat)
+	binary.Write(out, binary.BigEndian, math.Float64bits(f))
+	return nil
+}
+
+// writeETFInt uses the smallest integer tag that fits i.
+func writeETFInt(out *bytes.Buffer, i *big.Int) error {
+	if i.IsInt64() {
+		n := i.Int64()
+		switch {
+		case n >= 0 && n <= math.MaxUint8:
+			out.WriteByte(etfSmallInteger)
+			out.WriteByte(byte(n))
+			return nil
+		case n >= math.MinInt32 && n <= math.MaxInt32:
+			out.WriteByte(etfInteger)
+			binary.Write(out, binary.BigEndian, int32(n))
+			return nil
+		}
+	}
+
+	digits := new(big.Int).Abs(i).Bytes()
+	if len(digits) > etfMaxSmallBigBytes {
+		return errors.New("integer is too large for etf")
+	}
+
+	out.WriteByte(etfSmallBig)
+	out.WriteByte(byte(len(digits)))
+	if i.Sign() < 0 {
+		out.WriteByte(1)
+	} else {
+		out.WriteByte(0)
+	}
+
+	// big integers are stored little endian
+	for j := len(digits) - 1; j >= 0; j-- {
+		out.WriteByte(digits[j])
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// eventTypes are the types the fixtures in testdata are decoded into
+var eventTypes = map[string]func() interface{}{
+	readyEvent:             func() interface{} { return &ready{} },
+	messageCreateEvent:     func() interface{} { return &message{} },
+	interactionCreateEvent: func() interface{} { return &interaction{} },
+	voiceStateUpdateEvent:  func() interface{} { return &voiceStateUpdateResponse{} },
+}
+
+// readFixture returns a captured payload as the generic value
+// encoding/json decodes it into, numbers are kept as json.Number.
+func readFixture(t *testing.T, path string) interface{} {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	if err != nil {
+		t.Fatalf("error decoding %s: %v", path, err)
+	}
+	return v
+}
+
+// snowflakesToIntegers turns the ids of a fixture into numbers, as
+// Discord sends them as big integers over ETF.
+func snowflakesToIntegers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = snowflakesToIntegers(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = snowflakesToIntegers(e)
+		}
+		return out
+	case string:
+		if len(v) >= 17 && isSnowflake(v) {
+			return json.Number(v)
+		}
+	}
+	return v
+}
+
+// normalizeJSON marshals v and decodes it again, so values from both
+// encodings can be compared.
+func normalizeJSON(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out interface{}
+	err = json.Unmarshal(b, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func fixtures(t *testing.T) []string {
+	paths, err := filepath.Glob("testdata/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no fixtures in testdata")
+	}
+	return paths
+}
+
+func TestETFRoundTrip(t *testing.T) {
+	for _, path := range fixtures(t) {
+		fixture := readFixture(t, path)
+
+		b, err := marshalETF(fixture)
+		if err != nil {
+			t.Fatalf("%s: error encoding: %v", path, err)
+		}
+		term, err := decodeETF(b)
+		if err != nil {
+			t.Fatalf("%s: error decoding: %v", path, err)
+		}
+
+		if got, want := normalizeJSON(t, term), normalizeJSON(t, fixture); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip changed the payload\ngot  %v\nwant %v", path, got, want)
+		}
+	}
+}
+
+func TestETFPayloadMatchesJSON(t *testing.T) {
+	for _, path := range fixtures(t) {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fromJSON payload
+		err = json.Unmarshal(raw, &fromJSON)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		b, err := marshalETF(snowflakesToIntegers(readFixture(t, path)))
+		if err != nil {
+			t.Fatalf("%s: error encoding: %v", path, err)
+		}
+		fromETF, err := decodeETFPayload(b)
+		if err != nil {
+			t.Fatalf("%s: error decoding payload: %v", path, err)
+		}
+
+		if fromETF.Operation != fromJSON.Operation || fromETF.Sequence != fromJSON.Sequence || fromETF.Type != fromJSON.Type {
+			t.Errorf("%s: got op %d s %d t %s, want op %d s %d t %s", path,
+				fromETF.Operation, fromETF.Sequence, fromETF.Type,
+				fromJSON.Operation, fromJSON.Sequence, fromJSON.Type)
+		}
+
+		newEvent, ok := eventTypes[fromJSON.Type]
+		if !ok {
+			t.Fatalf("%s: no type for %s", path, fromJSON.Type)
+		}
+
+		want, got := newEvent(), newEvent()
+		err = fromJSON.EventData.decode(want)
+		if err != nil {
+			t.Fatalf("%s: error decoding json event: %v", path, err)
+		}
+		err = fromETF.EventData.decode(got)
+		if err != nil {
+			t.Fatalf("%s: error decoding etf event: %v", path, err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: etf decoded differently\ngot  %+v\nwant %+v", path, got, want)
+		}
+	}
+}
+
+func TestETFEncodeRoundTrip(t *testing.T) {
+	since := int64(1697566905000)
+	commands := []simplePayload{
+		{2, gatewayIdentification{
+			Token:    "token",
+			Specs:    properties{"linux", "go-bot", "go-bot"},
+			Shard:    &[2]int{1, 4},
+			Presence: &gatewayPresence{Since: &since, Activities: []activity{{Name: "music", Type: 2}}, Status: "online"},
+			Intents:  intentGuilds | intentGuildVoiceStates}},
+		{6, resume{"token", "6b2bd8d3a6f4cb2d", 1337}},
+		{4, voiceStateUpdate{GuildID: "1043190283553427466"}},
+		{1, int64(42)},
+	}
+
+	for _, c := range commands {
+		b, err := marshalETF(c)
+		if err != nil {
+			t.Fatalf("error encoding %T: %v", c.EventData, err)
+		}
+		p, err := decodeETFPayload(b)
+		if err != nil {
+			t.Fatalf("error decoding %T: %v", c.EventData, err)
+		}
+
+		got := reflect.New(reflect.TypeOf(c.EventData))
+		err = p.EventData.decode(got.Interface())
+		if err != nil {
+			t.Fatalf("error decoding %T: %v", c.EventData, err)
+		}
+
+		if p.Operation != c.Operation || !reflect.DeepEqual(got.Elem().Interface(), c.EventData) {
+			t.Errorf("op %d changed\ngot  %+v\nwant %+v", c.Operation, got.Elem().Interface(), c.EventData)
+		}
+	}
+
+	// fields left out by omitempty are left out of the etf map too
+	b, err := marshalETF(gatewayIdentification{Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	term, err := decodeETF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := normalizeJSON(t, term), normalizeJSON(t, gatewayIdentification{Token: "token"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDecodeETFTerms(t *testing.T) {
+	compressed := func(term []byte) []byte {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(term)
+		w.Close()
+		return append([]byte{etfVersion, etfCompressed, 0, 0, 0, byte(len(term))}, z.Bytes()...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"small integer", []byte{131, 97, 200}, int64(200)},
+		{"negative integer", []byte{131, 98, 255, 255, 255, 254}, int64(-2)},
+		{"snowflake", []byte{131, 110, 8, 0, 0, 160, 160, 211, 71, 37, 122, 14}, "1043187254113116160"},
+		{"negative big", []byte{131, 110, 1, 1, 5}, "-5"},
+		{"nil atom", []byte{131, 119, 3, 'n', 'i', 'l'}, nil},
+		{"true atom", []byte{131, 100, 0, 4, 't', 'r', 'u', 'e'}, true},
+		{"string", []byte{131, 107, 0, 2, 1, 2}, "\x01\x02"},
+		{"empty list", []byte{131, 106}, []interface{}{}},
+		{"tuple", []byte{131, 104, 2, 97, 1, 97, 2}, []interface{}{int64(1), int64(2)}},
+		{"map", []byte{131, 116, 0, 0, 0, 1, 109, 0, 0, 0, 2, 'o', 'p', 97, 10}, map[string]interface{}{"op": int64(10)}},
+		{"compressed", compressed([]byte{109, 0, 0, 0, 2, 'h', 'i'}), "hi"},
+	}
+
+	for _, test := range tests {
+		got, err := decodeETF(test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+
+	for _, bad := range [][]byte{nil, {97, 1}, {131, 109, 0, 0, 0, 5, 'a'}, {131, 97, 1, 2}, {131, 108, 0, 0, 0, 1, 97, 1, 97, 2}} {
+		if _, err := decodeETF(bad); err == nil {
+			t.Errorf("decoding %v should fail", bad)
+		}
+	}
+}
@@ -7,16 +7,42 @@ import (
 
 // payload is a wrapper for sending and receiving messages from Discord
 type payload struct {
-	Operation int             `json:"op"`
-	EventData json.RawMessage `json:"d"`
-	Sequence  int64           `json:"s"`
-	Type      string          `json:"t"`
+	Operation int       `json:"op"`
+	EventData eventData `json:"d"`
+	Sequence  int64     `json:"s"`
+	Type      string    `json:"t"`
 }
 
 // simplePayload is for messages that does not need a sequence number or type
 type simplePayload struct {
-	Operation int             `json:"op"`
-	EventData json.RawMessage `json:"d"`
+	Operation int         `json:"op"`
+	EventData interface{} `json:"d"`
+}
+
+// eventData is the data of a payload in the encoding it was received in,
+// it is decoded once a handler knows what type it has.
+type eventData struct {
+	raw json.RawMessage
+	// term is set instead of raw for etf payloads, see decodeETF
+	term interface{}
+	etf  bool
+}
+
+// UnmarshalJSON keeps the JSON so it can be decoded later.
+func (d *eventData) UnmarshalJSON(b []byte) error {
+	d.raw = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// decode stores the data in the value pointed to by v.
+func (d eventData) decode(v interface{}) error {
+	if d.etf {
+		return unmarshalTerm(d.term, v)
+	}
+	if len(d.raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(d.raw, v)
 }
 
 type ready struct {
@@ -25,32 +51,97 @@ type ready struct {
 	PrivateChannels    []int               `json:"private_channels"`
 	UnavailableGuildes []unavailableGuilde `json:"guilds"`
 	SeasionID          string              `json:"session_id"`
+	ResumeGatewayURL   string              `json:"resume_gateway_url"`
 	Trace              []string            `json:"_trace"`
+	Application        struct {
+		ID string `json:"id"`
+	} `json:"application"`
 }
 
+// resume Opcode 6
 type resume struct {
 	Token     string `json:"token"`
 	SessionID string `json:"session_id"`
-	Sequence  int    `json:"seq"`
+	Sequence  int64  `json:"seq"`
 }
 
 type message struct {
-	ID        string    `json:"id"`
-	ChannelID string    `json:"channel_id"`
-	GuildID   string    `json:"guild_id"`
-	Author    user      `json:"author"`
-	Content   string    `json:"content"`
-	Created   time.Time `json:"timestamp"`
-	Edited    time.Time `json:"edited_timestamp"`
-	TTS       bool      `json:"tts"`
+	ID               string            `json:"id"`
+	ChannelID        string            `json:"channel_id"`
+	GuildID          string            `json:"guild_id"`
+	Author           user              `json:"author"`
+	Member           *member           `json:"member"` // only sent for messages in guilds, without the user
+	Content          string            `json:"content"`
+	Created          time.Time         `json:"timestamp"`
+	Edited           time.Time         `json:"edited_timestamp"`
+	TTS              bool              `json:"tts"`
+	Mentions         []user            `json:"mentions"`
+	Attachments      []attachment      `json:"attachments"`
+	Embeds           []embed           `json:"embeds"`
+	MessageReference *messageReference `json:"message_reference"`
+	Components       []component       `json:"components"`
 	// Add more properties when needed
 }
 
+type embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	URL         string       `json:"url,omitempty"`
+	Timestamp   *time.Time   `json:"timestamp,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Footer      *embedFooter `json:"footer,omitempty"`
+	Image       *embedImage  `json:"image,omitempty"`
+	Thumbnail   *embedImage  `json:"thumbnail,omitempty"`
+	Author      *embedAuthor `json:"author,omitempty"`
+	Fields      []embedField `json:"fields,omitempty"`
+}
+
+type embedFooter struct {
+	Text    string `json:"text"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type embedImage struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+type embedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type embedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type attachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	URL         string `json:"url"`
+	ProxyURL    string `json:"proxy_url"`
+}
+
+// messageReference points to the message that a message replies to
+type messageReference struct {
+	MessageID       string `json:"message_id,omitempty"`
+	ChannelID       string `json:"channel_id,omitempty"`
+	GuildID         string `json:"guild_id,omitempty"`
+	FailIfNotExists *bool  `json:"fail_if_not_exists,omitempty"`
+}
+
+// voiceStateUpdate Opcode 4, a nil ChannelID leaves voice
 type voiceStateUpdate struct {
-	GuildID   string `json:"guild_id"`
-	ChannelID string `json:"channel_id"`
-	SelfMute  bool   `json:"self_mute"`
-	SelfDeaf  bool   `json:"self_deaf"`
+	GuildID   string  `json:"guild_id"`
+	ChannelID *string `json:"channel_id"`
+	SelfMute  bool    `json:"self_mute"`
+	SelfDeaf  bool    `json:"self_deaf"`
 }
 
 type voiceStateUpdateResponse struct {
@@ -74,10 +165,73 @@ type voiceServerUpdate struct {
 
 type member struct {
 	User     user      `json:"user"`
+	Nick     string    `json:"nick"`
 	Roles    []string  `json:"roles"`
 	Mute     bool      `json:"mute"`
 	JoinedAt time.Time `json:"joined_at"`
 	Deaf     bool      `json:"deaf"`
+	// Permissions are only sent with interactions, they are the permissions
+	// of the member in the channel of the interaction
+	Permissions string `json:"permissions"`
+}
+
+type guild struct {
+	ID          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	OwnerID     string                     `json:"owner_id"`
+	Unavailable bool                       `json:"unavailable"`
+	Roles       []role                     `json:"roles"`
+	Channels    []channel                  `json:"channels"`
+	Members     []member                   `json:"members"`
+	VoiceStates []voiceStateUpdateResponse `json:"voice_states"`
+}
+
+type channel struct {
+	ID                   string                `json:"id"`
+	Type                 int                   `json:"type"`
+	GuildID              string                `json:"guild_id"`
+	Name                 string                `json:"name"`
+	Position             int                   `json:"position"`
+	ParentID             string                `json:"parent_id"`
+	Bitrate              int                   `json:"bitrate"`
+	UserLimit            int                   `json:"user_limit"`
+	PermissionOverwrites []permissionOverwrite `json:"permission_overwrites"`
+}
+
+type permissionOverwrite struct {
+	ID    string `json:"id"`
+	Type  int    `json:"type"` // 0 for roles and 1 for members
+	Allow string `json:"allow"`
+	Deny  string `json:"deny"`
+}
+
+type role struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Color       int    `json:"color"`
+	Position    int    `json:"position"`
+	Permissions string `json:"permissions"`
+}
+
+type guildRoleUpdate struct {
+	GuildID string `json:"guild_id"`
+	Role    role   `json:"role"`
+}
+
+type guildRoleDelete struct {
+	GuildID string `json:"guild_id"`
+	RoleID  string `json:"role_id"`
+}
+
+// guildMemberUpdate is used for both GUILD_MEMBER_ADD and GUILD_MEMBER_UPDATE
+type guildMemberUpdate struct {
+	GuildID string `json:"guild_id"`
+	member
+}
+
+type guildMemberRemove struct {
+	GuildID string `json:"guild_id"`
+	User    user   `json:"user"`
 }
 
 type unavailableGuilde struct {
@@ -100,13 +254,23 @@ type user struct {
 
 const (
 	readyEvent             = "READY"
+	resumedEvent           = "RESUMED"
 	channelCreateEvent     = "CHANNEL_CREATE"
 	channelDeleteEvent     = "CHANNEL_DELETE"
 	channelUpdateEvent     = "CHANNEL_UPDATE"
 	connectEvent           = "__CONNECT__"
 	disconnectEvent        = "__DISCONNECT__"
+	reconnectFailedEvent   = "__RECONNECT_FAILED__"
 	guildCreateEvent       = "GUILD_CREATE"
+	guildDeleteEvent       = "GUILD_DELETE"
 	guildUpdateEvent       = "GUILD_UPDATE"
+	guildMemberAddEvent    = "GUILD_MEMBER_ADD"
+	guildMemberRemoveEvent = "GUILD_MEMBER_REMOVE"
+	guildMemberUpdateEvent = "GUILD_MEMBER_UPDATE"
+	guildRoleCreateEvent   = "GUILD_ROLE_CREATE"
+	guildRoleDeleteEvent   = "GUILD_ROLE_DELETE"
+	guildRoleUpdateEvent   = "GUILD_ROLE_UPDATE"
+	interactionCreateEvent = "INTERACTION_CREATE"
 	messageCreateEvent     = "MESSAGE_CREATE"
 	typingStartEvent       = "TYPING_START"
 	voiceServerUpdateEvent = "VOICE_SERVER_UPDATE"
@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
+	"math/rand"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,96 +19,357 @@ import (
 
 // gateway handles communcation with Discords websocket api
 type gateway struct {
-	token               string
-	wsMux               sync.Mutex
-	conn                *websocket.Conn
-	sequence            *int64
-	lastHeartbeatAck    time.Time
-	eventHandlers       map[string]func(json.RawMessage)
-	sessionInfo         ready
-	voiceUpdateResponse chan payload
+	token           string
+	wsMux           sync.Mutex
+	conn            *websocket.Conn
+	sequence        *int64
+	heartbeatAcked  int32
+	events          *dispatcher
+	sessionMu       sync.Mutex // guards sessionInfo, it is read when reconnecting
+	sessionInfo     ready
+	version         int
+	intents         int
+	encoding        string
+	compress        bool
+	zlibStream      bool
+	inflater        *zlibStream
+	largeThreshold  int
+	shard           *[2]int
+	presence        *gatewayPresence
+	url             string
+	identifyLimit   *identifyLimiter
+	sendLimit       *rateLimiter
+	reconnectPolicy reconnectPolicy
+	rest            *restClient
+}
+
+// reconnectPolicy decides how reconnecting is retried when dialing fails,
+// the delay doubles for every attempt up to maxDelay and is jittered so
+// shards does not retry at the same time.
+type reconnectPolicy struct {
+	// maxAttempts is the number of dials before giving up, 0 never gives up
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// backoff returns the delay before the next reconnect attempt.
+func (p reconnectPolicy) backoff(attempt int) time.Duration {
+	delay := p.maxDelay
+	if attempt < 32 && p.baseDelay<<uint(attempt-1) < p.maxDelay {
+		delay = p.baseDelay << uint(attempt-1)
+	}
+
+	// use a random delay between half and the full delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+const (
+	// Discord allows 120 gateway commands every 60 seconds
+	gatewaySendLimit = 120
+	// heartbeatReserve is the part of the send limit kept for heartbeats
+	heartbeatReserve = 3
+)
+
+// gatewayOption changes the default settings of a gateway
+type gatewayOption func(*gateway)
+
+// withVersion sets the gateway api version to connect to.
+func withVersion(v int) gatewayOption {
+	return func(g *gateway) {
+		g.version = v
+	}
+}
+
+// withIntents adds intents to the ones picked from the registered event
+// handlers. Privileged intents are never picked, they have to be enabled
+// for the bot in the developer portal and added with withIntents.
+func withIntents(i int) gatewayOption {
+	return func(g *gateway) {
+		g.intents = i
+	}
+}
+
+// withPayloadCompression asks Discord to zlib compress large payloads.
+func withPayloadCompression() gatewayOption {
+	return func(g *gateway) {
+		g.compress = true
+	}
+}
+
+// withETF makes the gateway use the Erlang External Term Format
+// instead of JSON for payloads.
+func withETF() gatewayOption {
+	return func(g *gateway) {
+		g.encoding = "etf"
+	}
+}
+
+// withZlibStream turns on the zlib-stream transport compression,
+// it replaces the compression set by withPayloadCompression.
+func withZlibStream() gatewayOption {
+	return func(g *gateway) {
+		g.zlibStream = true
+	}
+}
+
+// withLargeThreshold sets the member count (50-250) where Discord
+// stops sending offline members in GUILD_CREATE.
+func withLargeThreshold(n int) gatewayOption {
+	return func(g *gateway) {
+		g.largeThreshold = n
+	}
+}
+
+// withShard makes the gateway identify as shard id of count.
+func withShard(id, count int) gatewayOption {
+	return func(g *gateway) {
+		g.shard = &[2]int{id, count}
+	}
+}
+
+// withPresence sets the presence the bot gets when identifying.
+func withPresence(p gatewayPresence) gatewayOption {
+	return func(g *gateway) {
+		if p.Activities == nil {
+			p.Activities = []activity{}
+		}
+		g.presence = &p
+	}
+}
+
+// withURL skips asking Discord for the gateway url when connecting.
+func withURL(u string) gatewayOption {
+	return func(g *gateway) {
+		g.url = u
+	}
+}
+
+// withIdentifyLimiter makes the gateway wait for its turn
+// before identifying, used when running several shards.
+func withIdentifyLimiter(l *identifyLimiter) gatewayOption {
+	return func(g *gateway) {
+		g.identifyLimit = l
+	}
+}
+
+// withDispatcher makes the gateway deliver events to d, this
+// lets several shards share the same event handlers.
+func withDispatcher(d *dispatcher) gatewayOption {
+	return func(g *gateway) {
+		g.events = d
+	}
+}
+
+// withReconnectPolicy replaces the default reconnect policy.
+func withReconnectPolicy(p reconnectPolicy) gatewayOption {
+	return func(g *gateway) {
+		g.reconnectPolicy = p
+	}
+}
+
+// withRestClient sets the client used for the http api, shards share
+// one client so they share its rate limits.
+func withRestClient(c *restClient) gatewayOption {
+	return func(g *gateway) {
+		g.rest = c
+	}
 }
 
 // newGateway returns a client to subscribe on Discord events
 // sent via the gateway.
-func newGateway(t string) (*gateway, error) {
+func newGateway(t string, options ...gatewayOption) (*gateway, error) {
 	g := gateway{
-		token:               t,
-		sequence:            new(int64),
-		eventHandlers:       make(map[string]func(json.RawMessage)),
-		voiceUpdateResponse: make(chan payload)}
+		token:           t,
+		sequence:        new(int64),
+		events:          newDispatcher(false),
+		version:         10,
+		encoding:        "json",
+		sendLimit:       newRateLimiter(gatewaySendLimit-heartbeatReserve, time.Minute),
+		reconnectPolicy: reconnectPolicy{0, time.Second, time.Minute * 2}}
+
+	for _, option := range options {
+		option(&g)
+	}
+
+	if g.rest == nil {
+		g.rest = newRestClient(t)
+	}
+
+	if g.identifyLimit == nil {
+		g.identifyLimit = newIdentifyLimiter(g.rest, 1)
+	}
 
-	u, err := getWsURL()
+	err := g.dial()
 	if err != nil {
-		return &gateway{}, fmt.Errorf("error getting gateway url %v", err)
+		return &gateway{}, err
+	}
+
+	return &g, nil
+}
+
+// dial creates a new websocket connection to the gateway, sessions
+// are resumed on the url Discord gave in READY.
+func (g *gateway) dial() error {
+	u := g.url
+	if session := g.session(); g.canResume() && session.ResumeGatewayURL != "" {
+		u = session.ResumeGatewayURL
+	}
+	if u == "" {
+		var err error
+		u, err = g.rest.getGateway()
+		if err != nil {
+			return fmt.Errorf("error getting gateway url %v", err)
+		}
 	}
 
-	c, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c, _, err := websocket.DefaultDialer.Dial(g.dialURL(u), nil)
 	if err != nil {
-		return &gateway{}, fmt.Errorf("error creating gateway websocket connection: %v", err)
+		return fmt.Errorf("error creating gateway websocket connection: %v", err)
 	}
 
+	g.wsMux.Lock()
 	g.conn = c
-	return &g, nil
+	g.wsMux.Unlock()
+	g.sendLimit.reset()
+
+	// every connection starts a new compression context
+	if g.zlibStream {
+		g.inflater = &zlibStream{}
+	}
+
+	return nil
 }
 
 // open initiate a new gateway connection and start listening for events.
 func (g *gateway) open() {
-	g.lastHeartbeatAck = time.Now().UTC()
 	stopc := make(chan int)
-	var interval int
 
 	for {
-		_, message, err := g.conn.ReadMessage()
+		messageType, message, err := g.conn.ReadMessage()
 		if err != nil {
 			log.Printf("error reading gateway message: %v\n", err)
+			close(stopc)
+
+			// reconnecting after these would only be closed again
+			if reason, ok := fatalCloseReason(err); ok {
+				log.Printf("gateway closed the connection: %s, not reconnecting\n", reason)
+				g.wsMux.Lock()
+				g.conn.Close()
+				g.wsMux.Unlock()
+				g.emit(disconnectEvent)
+				g.emit(reconnectFailedEvent)
+				return
+			}
+
+			g.reconnect()
+			return
 		}
 
-		var pretty bytes.Buffer
-		json.Indent(&pretty, message, "", "    ")
-		log.Printf("received:\n%s\n", string(pretty.Bytes()))
+		// compressed payloads are sent as binary messages
+		if messageType == websocket.BinaryMessage && g.inflater != nil {
+			var complete bool
+			message, complete, err = g.inflater.inflate(message)
+			if err != nil {
+				log.Printf("error inflating zlib stream: %v\n", err)
+				close(stopc)
+				g.reconnect()
+				return
+			}
+
+			// wait for the rest of the message
+			if !complete {
+				continue
+			}
+		} else if messageType == websocket.BinaryMessage && (len(message) == 0 || message[0] != etfVersion) {
+			message, err = inflatePayload(message)
+			if err != nil {
+				log.Printf("error inflating payload: %v\n", err)
+				continue
+			}
+		}
 
 		var p payload
-		err = json.Unmarshal(message, &p)
-		if err != nil {
-			log.Printf("error unmarshalling payload: %v\n", err)
+		if g.encoding == "etf" {
+			p, err = decodeETFPayload(message)
+			if err != nil {
+				log.Printf("error decoding etf payload: %v\n", err)
+				continue
+			}
+			log.Printf("received etf payload op %d %s\n", p.Operation, p.Type)
+		} else {
+			var pretty bytes.Buffer
+			json.Indent(&pretty, message, "", "    ")
+			log.Printf("received:\n%s\n", string(pretty.Bytes()))
+
+			err = json.Unmarshal(message, &p)
+			if err != nil {
+				log.Printf("error unmarshalling payload: %v\n", err)
+			}
 		}
 
 		// Hello event
 		if p.Operation == 10 {
 			var he gatewayHello
-			err := json.Unmarshal(p.EventData, &he)
+			err := p.EventData.decode(&he)
 			if err != nil {
 				log.Printf("error unmarshalling gatewayhello: %v\n", err)
 			}
 
-			g.identify()
-
-			interval = he.HeartbeatInterval
 			go g.startHeart(he.HeartbeatInterval, stopc)
+
+			g.startSession()
 		}
 
 		// Heartbeat ACK event
 		if p.Operation == 11 {
-			g.lastHeartbeatAck = time.Now().UTC()
+			atomic.StoreInt32(&g.heartbeatAcked, 1)
 			log.Println("received gateway ACK")
 		}
 
-		if time.Now().UTC().Sub(g.lastHeartbeatAck) > time.Millisecond*time.Duration(interval) {
-			stopc <- 0
+		// Opcode 1 is a heartbeat request from the Discord gateway
+		if p.Operation == 1 {
+			err := g.writeNow(gatewayHeartbeat{1, atomic.LoadInt64(g.sequence)})
+			if err != nil {
+				log.Printf("error sending heartbeat on request of gateway: %v\n", err)
+			}
+		}
+
+		// Opcode 7 means Discord wants us to reconnect and resume
+		if p.Operation == 7 {
+			log.Println("gateway requested reconnect")
+			close(stopc)
 			g.reconnect()
 			return
 		}
 
-		// Opcode 1 is a heartbeat request from the Discord gateway
-		if p.Operation == 1 {
-			g.wsMux.Lock()
-			err := g.conn.WriteJSON(gatewayHeartbeat{1, atomic.LoadInt64(g.sequence)})
-			g.wsMux.Unlock()
-
+		// Invalid session, the event data tells if the session can be resumed
+		if p.Operation == 9 {
+			var resumable bool
+			err := p.EventData.decode(&resumable)
 			if err != nil {
-				log.Printf("error sending heartbeat on request of gateway: %v\n", err)
+				log.Printf("error unmarshalling invalid session: %v\n", err)
+			}
+
+			if !resumable {
+				g.resetSession()
 			}
+
+			g.emit(disconnectEvent)
+
+			// Discord wants us to wait a random amount of time between 1
+			// and 5 seconds before sending a new identify. The wait is not
+			// done here so heartbeat ACKs are still read meanwhile.
+			delay := time.Millisecond * time.Duration(1000+rand.Intn(4000))
+			time.AfterFunc(delay, func() {
+				// the connection may have been replaced while waiting
+				select {
+				case <-stopc:
+					return
+				default:
+				}
+				g.startSession()
+			})
 		}
 
 		if p.Operation == 0 {
@@ -119,52 +383,187 @@ func (g *gateway) handleEvent(p payload) {
 	log.Println(p.Type)
 	if p.Type == readyEvent {
 		var r ready
-		err := json.Unmarshal(p.EventData, &r)
+		err := p.EventData.decode(&r)
 		if err != nil {
 			log.Println("could not unmarshal readyEvent:", err)
 		}
+		g.sessionMu.Lock()
 		g.sessionInfo = r
+		g.sessionMu.Unlock()
+	}
+
+	if p.Type == resumedEvent {
+		log.Println("gateway session resumed")
 	}
 
-	if p.Type == voiceStateUpdateEvent || p.Type == voiceServerUpdateEvent {
-		g.voiceUpdateResponse <- p
+	if p.Type == readyEvent || p.Type == resumedEvent {
+		g.emit(connectEvent)
 	}
 
-	if _, ok := g.eventHandlers[p.Type]; ok {
-		go g.eventHandlers[p.Type](p.EventData)
+	g.events.dispatch(p.Type, p.EventData)
+}
+
+// write sends a payload to the gateway once the send rate limit allows it.
+func (g *gateway) write(v interface{}) error {
+	g.sendLimit.wait()
+	return g.writeNow(v)
+}
+
+// writeNow sends a payload using the configured encoding without
+// waiting on the rate limit, only heartbeats should use it directly.
+func (g *gateway) writeNow(v interface{}) error {
+	g.wsMux.Lock()
+	defer g.wsMux.Unlock()
+
+	if g.encoding != "etf" {
+		return g.conn.WriteJSON(v)
+	}
+
+	b, err := marshalETF(v)
+	if err != nil {
+		return fmt.Errorf("error encoding etf payload: %v", err)
 	}
+
+	return g.conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// emit runs the handlers registered for one of the events
+// that are generated by the gateway itself, such as connectEvent.
+func (g *gateway) emit(event string) {
+	g.events.dispatch(event, eventData{})
+}
+
+// startSession resumes the previous session if there is one, that way
+// Discord will replay every event we missed while disconnected. New
+// sessions are identified in the background since the identify
+// limiter can make us wait for a while.
+func (g *gateway) startSession() {
+	if g.canResume() {
+		err := g.resume()
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	go func() {
+		err := g.identify()
+		if err != nil {
+			log.Println(err)
+		}
+	}()
 }
 
 func (g *gateway) identify() error {
+	g.identifyLimit.wait(g.shardID())
+
 	log.Println("sending gateway identification")
 
-	ide, err := json.Marshal(gatewayIdentification{g.token, properties{"windows", "go-bot", "go-bot"}})
+	intents := g.neededIntents() | g.intents
+
+	ide := gatewayIdentification{
+		Token:          g.token,
+		Specs:          properties{"windows", "go-bot", "go-bot"},
+		Compress:       g.compress && !g.zlibStream,
+		LargeThreshold: g.largeThreshold,
+		Shard:          g.shard,
+		Presence:       g.presence,
+		Intents:        intents}
+
+	err := g.write(simplePayload{2, ide})
 	if err != nil {
-		return fmt.Errorf("failed to marshal gateway identification: %v", err)
+		return fmt.Errorf("failed to send identification: %v", err)
 	}
 
-	g.wsMux.Lock()
-	err = g.conn.WriteJSON(simplePayload{2, ide})
-	g.wsMux.Unlock()
+	return nil
+}
+
+// neededIntents returns the intents required by the gateway itself
+// and the events that has a registered handler.
+func (g *gateway) neededIntents() int {
+	// the voice connection relies on guild and voice state events
+	intents := intentGuilds | intentGuildVoiceStates
+
+	for _, event := range g.events.events() {
+		intents |= eventIntents[event]
+	}
+
+	return intents
+}
 
+// resume asks Discord to continue the previous session, all events
+// after the last received sequence number will be replayed.
+func (g *gateway) resume() error {
+	log.Println("resuming gateway session")
+
+	res := resume{g.token, g.session().SeasionID, atomic.LoadInt64(g.sequence)}
+
+	err := g.write(simplePayload{6, res})
 	if err != nil {
-		return fmt.Errorf("failed to send identification: %v", err)
+		return fmt.Errorf("failed to send resume: %v", err)
 	}
 
 	return nil
 }
 
+// shardID returns the shard the gateway identifies as.
+func (g *gateway) shardID() int {
+	if g.shard == nil {
+		return 0
+	}
+	return g.shard[0]
+}
+
+// session returns a copy of the READY of the current session.
+func (g *gateway) session() ready {
+	g.sessionMu.Lock()
+	defer g.sessionMu.Unlock()
+
+	return g.sessionInfo
+}
+
+// canResume reports if there is a session that can be resumed.
+func (g *gateway) canResume() bool {
+	return g.session().SeasionID != "" && atomic.LoadInt64(g.sequence) > 0
+}
+
+// resetSession forgets the current session so the next
+// connection has to identify again.
+func (g *gateway) resetSession() {
+	g.sessionMu.Lock()
+	g.sessionInfo.SeasionID = ""
+	g.sessionMu.Unlock()
+	atomic.StoreInt64(g.sequence, 0)
+}
+
+// startHeart sends heartbeats until stop is closed. It also works as
+// a watchdog, if the previous heartbeat was never acknowledged the
+// connection is closed so open can reconnect.
 func (g *gateway) startHeart(interval int, stop chan int) {
 	log.Println("heart started")
+	atomic.StoreInt32(&g.heartbeatAcked, 1)
+
+	// Discord wants the first heartbeat to be sent after a random
+	// part of the interval so all clients does not beat at once
+	select {
+	case <-time.After(time.Duration(rand.Float64() * float64(time.Millisecond) * float64(interval))):
+	case <-stop:
+		return
+	}
 
 	ticker := time.NewTicker(time.Millisecond * time.Duration(interval))
 	defer ticker.Stop()
 
 	for {
-		g.wsMux.Lock()
-		err := g.conn.WriteJSON(gatewayHeartbeat{1, atomic.LoadInt64(g.sequence)})
-		g.wsMux.Unlock()
+		if !atomic.CompareAndSwapInt32(&g.heartbeatAcked, 1, 0) {
+			log.Println("gateway heartbeat was not acknowledged, closing zombie connection")
+			g.wsMux.Lock()
+			g.conn.Close()
+			g.wsMux.Unlock()
+			return
+		}
 
+		err := g.writeNow(gatewayHeartbeat{1, atomic.LoadInt64(g.sequence)})
 		if err != nil {
 			log.Printf("error sending gateway heartbeat: %v\n", err)
 		}
@@ -177,77 +576,139 @@ func (g *gateway) startHeart(interval int, stop chan int) {
 	}
 }
 
+// reconnect replaces the gateway connection, the session is kept
+// so open can resume it once Discord says hello. Failed dials are
+// retried according to the reconnect policy.
 func (g *gateway) reconnect() {
 	log.Println("reconnecting to gateway")
+	g.emit(disconnectEvent)
 
 	g.wsMux.Lock()
 	g.conn.Close()
+	g.wsMux.Unlock()
 
-	u, err := getWsURL()
-	if err != nil {
-		log.Printf("error getting websocket url while reconnecting: %v", err)
-		return
-	}
+	var err error
+	for attempt := 1; g.reconnectPolicy.maxAttempts == 0 || attempt <= g.reconnectPolicy.maxAttempts; attempt++ {
+		err = g.dial()
+		if err == nil {
+			go g.open()
+			return
+		}
 
-	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
-	if err != nil {
-		log.Fatal(fmt.Sprintf("failed to reconnect: %v\n exiting..", err))
-		// would probably be good to terminate the voice connecion when this happens
-		return
+		delay := g.reconnectPolicy.backoff(attempt)
+		log.Printf("reconnect attempt %d failed: %v, retrying in %v\n", attempt, err, delay)
+		time.Sleep(delay)
 	}
 
-	g.conn = conn
-	g.wsMux.Unlock()
-
-	go g.open()
+	log.Printf("giving up reconnecting to gateway: %v\n", err)
+	g.emit(reconnectFailedEvent)
 }
 
 // requestVoice sends a VoiceStateUpdate to the Discord voice server to
 // let it know that we want to connect, Discord should responed with
 // a VOICE_SERVER_UPDATE event and a VOICE_STATE_UPDATE event
-func (g *gateway) requestVoice(channelID string) error {
+func (g *gateway) requestVoice(guildID, channelID string) error {
+	err := g.updateVoiceState(guildID, &channelID)
+	if err != nil {
+		return fmt.Errorf("failed to send voice request: %v", err)
+	}
+
+	log.Println("voice request sent")
+	return nil
+}
+
+// leaveVoice tells Discord that we left the voice channel in the guild.
+func (g *gateway) leaveVoice(guildID string) error {
+	err := g.updateVoiceState(guildID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to send voice leave: %v", err)
+	}
+
+	log.Println("voice leave sent")
+	return nil
+}
+
+func (g *gateway) updateVoiceState(guildID string, channelID *string) error {
 	voiceState := voiceStateUpdate{
-		GuildID:   g.sessionInfo.UnavailableGuildes[0].GuildID,
+		GuildID:   guildID,
 		ChannelID: channelID,
 		SelfMute:  false,
 		SelfDeaf:  false}
 
-	jsonData, err := json.Marshal(voiceState)
-	if err != nil {
-		return fmt.Errorf("error parsing voice state data: %v", err)
+	return g.write(simplePayload{4, voiceState})
+}
+
+// dialURL adds the gateway settings to the url returned by getGateway.
+func (g *gateway) dialURL(u string) string {
+	u = fmt.Sprintf("%s/?v=%d&encoding=%s", strings.TrimSuffix(u, "/"), g.version, g.encoding)
+	if g.zlibStream {
+		u += "&compress=zlib-stream"
 	}
+	return u
+}
 
-	g.wsMux.Lock()
-	err = g.conn.WriteJSON(simplePayload{4, jsonData})
-	g.wsMux.Unlock()
+// decodeETFPayload decodes a payload sent with the etf encoding, the
+// event data is kept as a term until a handler decodes it.
+func decodeETFPayload(b []byte) (payload, error) {
+	var p payload
+
+	term, err := decodeETF(b)
 	if err != nil {
-		return fmt.Errorf("failed to send voice request: %v", err)
+		return p, err
 	}
 
-	log.Println("voice request sent")
-	return nil
+	err = unmarshalTerm(term, &p)
+	return p, err
 }
 
-func getWsURL() (string, error) {
-	resp, err := http.Get("https://discordapp.com/api/gateway")
-	if err != nil {
-		return "", fmt.Errorf("failed to get websocket url: %v", err)
-	}
+// fatalCloseCodes are the close codes sent by Discord for problems
+// that reconnecting does not fix
+var fatalCloseCodes = map[int]string{
+	4004: "authentication failed",
+	4010: "invalid shard",
+	4011: "sharding required",
+	4012: "invalid api version",
+	4013: "invalid intents",
+	4014: "disallowed intents, a privileged intent is not enabled for the bot",
+}
 
-	defer resp.Body.Close()
+// fatalCloseReason tells if err is a close frame with a fatal close code.
+func fatalCloseReason(err error) (string, bool) {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return "", false
+	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read wsURL response: %v", err)
+	reason, ok := fatalCloseCodes[closeErr.Code]
+	if !ok {
+		return "", false
 	}
+	return fmt.Sprintf("%d %s", closeErr.Code, reason), true
+}
 
-	var u wsURL
-	err = json.Unmarshal(body, &u)
+// inflatePayload decompresses a payload sent when compress is set
+// in the identification.
+func inflatePayload(b []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(b))
 	if err != nil {
-		return "", fmt.Errorf("error unmarshalling wsUrl: %v", err)
+		return nil, fmt.Errorf("error creating zlib reader: %v", err)
 	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
 
-	return u.URL, nil
+type gatewayBot struct {
+	URL               string            `json:"url"`
+	Shards            int               `json:"shards"`
+	SessionStartLimit sessionStartLimit `json:"session_start_limit"`
+}
+
+type sessionStartLimit struct {
+	Total          int `json:"total"`
+	Remaining      int `json:"remaining"`
+	ResetAfter     int `json:"reset_after"`
+	MaxConcurrency int `json:"max_concurrency"`
 }
 
 type wsURL struct {
@@ -268,8 +729,27 @@ type gatewayHello struct {
 
 // IdentifyEvent Opcode 2
 type gatewayIdentification struct {
-	Token string     `json:"token"`
-	Specs properties `json:"properties"`
+	Token          string           `json:"token"`
+	Specs          properties       `json:"properties"`
+	Compress       bool             `json:"compress,omitempty"`
+	LargeThreshold int              `json:"large_threshold,omitempty"`
+	Shard          *[2]int          `json:"shard,omitempty"`
+	Presence       *gatewayPresence `json:"presence,omitempty"`
+	Intents        int              `json:"intents"`
+}
+
+// gatewayPresence is the status shown for the bot
+type gatewayPresence struct {
+	Since      *int64     `json:"since"`
+	Activities []activity `json:"activities"`
+	Status     string     `json:"status"`
+	AFK        bool       `json:"afk"`
+}
+
+type activity struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	URL  string `json:"url,omitempty"`
 }
 
 // Properties contains specs that are needed for
@@ -279,3 +759,43 @@ type properties struct {
 	Browser        string `json:"browser"`
 	Device         string `json:"device"`
 }
+
+// Gateway intents, used to pick which events Discord should send
+const (
+	intentGuilds                 = 1 << 0
+	intentGuildMembers           = 1 << 1
+	intentGuildModeration        = 1 << 2
+	intentGuildEmojis            = 1 << 3
+	intentGuildIntegrations      = 1 << 4
+	intentGuildWebhooks          = 1 << 5
+	intentGuildInvites           = 1 << 6
+	intentGuildVoiceStates       = 1 << 7
+	intentGuildPresences         = 1 << 8
+	intentGuildMessages          = 1 << 9
+	intentGuildMessageReactions  = 1 << 10
+	intentGuildMessageTyping     = 1 << 11
+	intentDirectMessages         = 1 << 12
+	intentDirectMessageReactions = 1 << 13
+	intentDirectMessageTyping    = 1 << 14
+	intentMessageContent         = 1 << 15
+)
+
+// eventIntents maps events to the intents needed to receive them. Only
+// intents that are not privileged are listed, Discord closes the connection
+// when a privileged intent is not enabled for the bot. Member events and
+// the content of messages are left out for that reason.
+var eventIntents = map[string]int{
+	channelCreateEvent:     intentGuilds,
+	channelDeleteEvent:     intentGuilds,
+	channelUpdateEvent:     intentGuilds,
+	guildCreateEvent:       intentGuilds,
+	guildDeleteEvent:       intentGuilds,
+	guildUpdateEvent:       intentGuilds,
+	guildRoleCreateEvent:   intentGuilds,
+	guildRoleDeleteEvent:   intentGuilds,
+	guildRoleUpdateEvent:   intentGuilds,
+	messageCreateEvent:     intentGuildMessages | intentDirectMessages,
+	typingStartEvent:       intentGuildMessageTyping | intentDirectMessageTyping,
+	voiceStateUpdateEvent:  intentGuildVoiceStates,
+	voiceServerUpdateEvent: intentGuildVoiceStates,
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGatewaySession(t *testing.T) {
+	g := &gateway{sequence: new(int64), events: newDispatcher(false)}
+	ready := payload{Operation: 0, Sequence: 1, Type: readyEvent, EventData: eventData{
+		raw: []byte(`{"session_id": "abc", "resume_gateway_url": "wss://resume.discord.gg"}`)}}
+
+	// READY is handled by the read loop while a reconnect reads the session
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			atomic.StoreInt64(g.sequence, 1)
+			g.handleEvent(ready)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			g.canResume()
+			g.session()
+		}
+	}()
+	wg.Wait()
+
+	if !g.canResume() || g.session().ResumeGatewayURL != "wss://resume.discord.gg" {
+		t.Errorf("got session %+v, want the session from READY", g.session())
+	}
+
+	g.resetSession()
+	if g.canResume() {
+		t.Error("a reset session should not be resumed")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Interaction types
+const (
+	interactionPing               = 1
+	interactionApplicationCommand = 2
+	interactionMessageComponent   = 3
+	interactionAutocomplete       = 4
+	interactionModalSubmit        = 5
+)
+
+// Interaction response types
+const (
+	responsePong                   = 1
+	responseChannelMessage         = 4
+	responseDeferredChannelMessage = 5
+	responseDeferredUpdateMessage  = 6
+	responseUpdateMessage          = 7
+	responseAutocomplete           = 8
+)
+
+// Application command option types
+const (
+	optionSubCommand      = 1
+	optionSubCommandGroup = 2
+	optionString          = 3
+	optionInteger         = 4
+	optionBoolean         = 5
+	optionUser            = 6
+	optionChannel         = 7
+	optionRole            = 8
+	optionNumber          = 10
+)
+
+// interaction is sent when a user uses a slash command or a component
+type interaction struct {
+	ID            string          `json:"id"`
+	ApplicationID string          `json:"application_id"`
+	Type          int             `json:"type"`
+	Data          interactionData `json:"data"`
+	GuildID       string          `json:"guild_id"`
+	ChannelID     string          `json:"channel_id"`
+	Member        *member         `json:"member"` // set in guilds
+	User          *user           `json:"user"`   // set in direct messages
+	Token         string          `json:"token"`
+	Message       *message        `json:"message"` // the message of a component
+}
+
+type interactionData struct {
+	ID      string              `json:"id"`
+	Name    string              `json:"name"`
+	Type    int                 `json:"type"`
+	Options []interactionOption `json:"options"`
+	// set for components
+	CustomID      string   `json:"custom_id"`
+	ComponentType int      `json:"component_type"`
+	Values        []string `json:"values"`
+}
+
+type interactionOption struct {
+	Name    string              `json:"name"`
+	Type    int                 `json:"type"`
+	Value   json.RawMessage     `json:"value"`
+	Options []interactionOption `json:"options"`
+	Focused bool                `json:"focused"`
+}
+
+// author returns the user that caused the interaction.
+func (i *interaction) author() user {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return *i.User
+	}
+	return user{}
+}
+
+// request returns who used the interaction and where.
+func (i *interaction) request() commandRequest {
+	return commandRequest{i.GuildID, i.ChannelID, i.author().ID, i.Member}
+}
+
+// option returns an option by its name.
+func (d *interactionData) option(name string) (*interactionOption, bool) {
+	for i := range d.Options {
+		if d.Options[i].Name == name {
+			return &d.Options[i], true
+		}
+	}
+	return nil, false
+}
+
+// stringOption returns the value of a string option, or "" if it is missing.
+func (d *interactionData) stringOption(name string) string {
+	o, ok := d.option(name)
+	if !ok {
+		return ""
+	}
+
+	var s string
+	json.Unmarshal(o.Value, &s)
+	return s
+}
+
+// intOption returns the value of an integer option, ok is false if it is missing.
+func (d *interactionData) intOption(name string) (int, bool) {
+	o, ok := d.option(name)
+	if !ok {
+		return 0, false
+	}
+
+	var n int
+	err := json.Unmarshal(o.Value, &n)
+	return n, err == nil
+}
+
+type interactionResponse struct {
+	Type int         `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// encode returns the content type and the body of the response, messages
+// with files are sent as multipart/form-data.
+func (r *interactionResponse) encode() (string, []byte, error) {
+	send, ok := r.Data.(*messageSend)
+	if !ok || len(send.Files) == 0 {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("error marshalling interaction response: %v", err)
+		}
+		return "application/json", b, nil
+	}
+
+	return multipartBody(&interactionResponse{r.Type, attachFiles(send)}, send.Files)
+}
+
+// interactionContext responds to an interaction. The first response has
+// to be sent within 3 seconds, slow handlers should defer the reply and
+// reply when they are done.
+type interactionContext struct {
+	rest        *restClient
+	interaction *interaction
+	// respond sends the first response, which is done differently for
+	// the gateway and for the http endpoint
+	respond func(*interactionResponse) error
+
+	mu        sync.Mutex
+	responded bool
+	deferred  bool
+}
+
+// gatewayResponder sends the first response of an interaction received on
+// the gateway with the interaction callback endpoint.
+func gatewayResponder(rest *restClient, i *interaction) func(*interactionResponse) error {
+	return func(r *interactionResponse) error {
+		contentType, body, err := r.encode()
+		if err != nil {
+			return err
+		}
+		return rest.do("POST", "/interactions/"+i.ID+"/"+i.Token+"/callback", contentType, body, nil)
+	}
+}
+
+// reply responds with a message, if the reply was deferred the
+// deferred response is edited instead.
+func (ctx *interactionContext) reply(send *messageSend) error {
+	ctx.mu.Lock()
+	deferred := ctx.deferred
+	ctx.mu.Unlock()
+
+	if deferred {
+		_, err := ctx.editReply(send)
+		return err
+	}
+
+	return ctx.sendResponse(&interactionResponse{Type: responseChannelMessage, Data: send})
+}
+
+// deferReply shows that the bot is thinking, reply has to be called later.
+func (ctx *interactionContext) deferReply() error {
+	err := ctx.sendResponse(&interactionResponse{Type: responseDeferredChannelMessage})
+	if err != nil {
+		return err
+	}
+
+	ctx.mu.Lock()
+	ctx.deferred = true
+	ctx.mu.Unlock()
+	return nil
+}
+
+// sendResponse sends the first response, an interaction can only be
+// responded to once.
+func (ctx *interactionContext) sendResponse(r *interactionResponse) error {
+	ctx.mu.Lock()
+	if ctx.responded {
+		ctx.mu.Unlock()
+		return fmt.Errorf("interaction %s has already been responded to", ctx.interaction.ID)
+	}
+	ctx.responded = true
+	ctx.mu.Unlock()
+
+	err := ctx.respond(r)
+	if err != nil {
+		return fmt.Errorf("error responding to interaction: %v", err)
+	}
+	return nil
+}
+
+// editReply edits the first response.
+func (ctx *interactionContext) editReply(send *messageSend) (*message, error) {
+	var m message
+	err := ctx.rest.sendMessageData("PATCH", ctx.webhookPath()+"/messages/@original", send, &m)
+	if err != nil {
+		return nil, fmt.Errorf("error editing interaction response: %v", err)
+	}
+	return &m, nil
+}
+
+// followUp sends another message after the first response.
+func (ctx *interactionContext) followUp(send *messageSend) (*message, error) {
+	var m message
+	err := ctx.rest.sendMessageData("POST", ctx.webhookPath(), send, &m)
+	if err != nil {
+		return nil, fmt.Errorf("error sending follow-up message: %v", err)
+	}
+	return &m, nil
+}
+
+// webhookPath is the path of the webhook used for follow-up messages.
+func (ctx *interactionContext) webhookPath() string {
+	return "/webhooks/" + ctx.interaction.ApplicationID + "/" + ctx.interaction.Token
+}
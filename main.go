@@ -2,235 +2,152 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
 	"strings"
-
-	"layeh.com/gopus"
 )
 
 func main() {
+	interactionsAddr := flag.String("interactions", "", "receive interactions over http on this address instead of the gateway, such as :8080")
+	publicKey := flag.String("public-key", "", "hex encoded public key of the application, needed with -interactions")
+	messageContent := flag.Bool("message-content", true, "request the privileged message content intent, prefix commands need it but mentions of the bot work without it")
+	flag.Parse()
+
 	token, err := readToken()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	gw, err := newGateway(token)
+	rest := newRestClient(token)
+
+	var options []gatewayOption
+	if *messageContent {
+		options = append(options, withIntents(intentMessageContent))
+	}
+
+	shards, err := newShardManager(rest, options...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	voi := newVoice()
-
-	gw.eventHandlers[messageCreateEvent] = func(data json.RawMessage) {
-		var m message
-		err := json.Unmarshal(data, &m)
-		if err != nil {
-			log.Println("error parsing json", err)
-			return
-		}
-
-		if m.Content != "!s" && m.Content != "!e" {
-			return
-		}
+	guildState := newState()
+	guildState.register(shards.events)
 
-		var cID string
-		if m.Content == "!s" {
-			cID = "327232994102214656"
-		}
+	voices := newVoiceManager(shards, guildState)
 
-		if m.Content == "!e" {
-			voi.speaking(false)
-			cID = "220255406964998144"
+	// the voice connections does not depend on the gateway, so they
+	// are only paused when the gateway can not be reconnected
+	shards.events.onReconnectFailed(func() {
+		log.Println("lost the gateway connection, pausing voice")
+		for _, voi := range voices.all() {
+			if voi.running {
+				voi.speaking(false)
+			}
 		}
+	})
 
-		const (
-			channels  int = 2                   // 1 for mono, 2 for stereo
-			frameRate int = 48000               // audio sampling rate
-			frameSize int = 960                 // uint16 size of each audio frame
-			maxBytes  int = (frameSize * 2) * 2 // max size of opus data
-		)
+	players := newPlayerManager()
+	commands := newCommandRegistry(rest)
 
-		opusEncoder, err := gopus.NewEncoder(frameRate, channels, gopus.Audio)
-		if err != nil {
-			log.Printf("error creating encoder: %v\n", err)
-			return
-		}
+	bot := newMusicBot(rest, guildState, voices, players, commands)
+	shards.events.onMessageCreate(bot.router.handleMessage)
+	bot.registerCommands()
+	commands.listen(shards.events)
 
-		// ####### download video as webm #######
-
-		connected, err := voi.establishConnection(cID, gw)
+	// Discord only sends interactions to the http endpoint once its url is
+	// set in the developer portal, until then they come over the gateway
+	if *interactionsAddr != "" {
+		server, err := newInteractionServer(*publicKey, commands)
 		if err != nil {
-			log.Printf("error establishing voice connection: %v\n", err)
-			return
-		}
-
-		err = <-connected
-		if err != nil {
-			log.Printf("error establishing voice connection: %v\n", err)
+			log.Fatal(err)
 		}
 
 		go func() {
-			err := <-connected
-			if err != nil {
-				log.Printf("voice connection error: %v\n", err)
-			}
+			log.Printf("receiving interactions on %s\n", *interactionsAddr)
+			log.Fatal(http.ListenAndServe(*interactionsAddr, server))
 		}()
+	}
 
-		voi.speaking(true)
-
-		yID := "8-kcWrCX9rA" // 1:58 long
-		filename := yID + ".webm"
-
-		cmd := exec.Command("youtube-dl", "-f 251", yID, "--id")
-		err = cmd.Run()
-		if err != nil {
-			log.Printf("error downloading youtube video: %v\n", err)
-			log.Println("trying again..")
-
-			cmd := exec.Command("youtube-dl", "-f 251", yID, "--id")
-			err = cmd.Run()
-
-			if err != nil {
-				log.Printf("failed to download youtube video on second attempt: %v\n", err)
-				return
-			}
-		}
-
-		//####### extract opus data with ffmpeg #######
-
-		ffmpegCMD := exec.Command("ffmpeg", "-i", filename, "-f", "s16le", "-ar", strconv.Itoa(frameRate), "-ac", strconv.Itoa(channels), "pipe:1")
-		ffmpegout, err := ffmpegCMD.StdoutPipe()
-		if err != nil {
-			log.Printf("stdoutPipe error: %v\n", err)
-			return
-		}
-
-		ffmpegCMD.Start()
-		if err != nil {
-			log.Printf("error running ffmpeg: %v\n", err)
-			return
-		}
-
-		ffmpegbuf := bufio.NewReaderSize(ffmpegout, 16384)
-
-		for {
-			// read data from ffmpeg stdout
-			audiobuf := make([]int16, frameSize*channels)
-			err = binary.Read(ffmpegbuf, binary.LittleEndian, &audiobuf)
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return
-			}
-			if err != nil {
-				log.Printf("error reading from ffmpeg stdout: %v\n", err)
-				return
-			}
-
-			// Send received PCM to the sendPCM channel
-
-			opus, err := opusEncoder.Encode(audiobuf, frameSize, maxBytes)
-			if err != nil {
-				log.Printf("error encoding opus: %v\n", err)
-				return
-			}
-
-			//log.Println("opus: ", len(opus))
-			voi.sendOpusData(opus)
-		}
-
-		// ffmpegCMD.Wait()
-		// if err != nil {
-		// 	log.Printf("error waiting for ffmpeg: %v\n", err)
-		// 	return
-		// }
+	shards.open()
 
-		//url := "https://discordapp.com/api/v6/channels/" + m.ChannelID + "/messages"
-		// var jsonStr = []byte(`{"content":"Establishing voice", "tts": false}`)
-		// req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
-		// req.Header.Set("Content-Type", "application/json")
-		// req.Header.Set("Authorization", "Bot "+token)
+	bufio.NewReader(os.Stdin).ReadBytes('\n')
+}
 
-		// if err != nil {
-		// 	log.Println(err)
-		// }
+// authorVoiceChannel returns the voice channel a user is in, as long as
+// the bot is allowed to connect and speak there. The error is meant to
+// be shown to the user.
+func authorVoiceChannel(s *state, guildID, userID string) (string, error) {
+	if guildID == "" {
+		return "", errors.New("Music only works in servers")
+	}
 
-		// client := &http.Client{}
-		// _, err = client.Do(req)
+	cID, ok := s.voiceChannelOf(guildID, userID)
+	if !ok {
+		return "", errors.New("Join a voice channel first")
+	}
 
-		// if err != nil {
-		// 	log.Println(err)
-		// }
+	perms, err := s.channelPermissions(cID, s.botUser().ID)
+	if err != nil {
+		log.Printf("error checking voice permissions: %v\n", err)
+		return "", errors.New("Could not check my permissions in your voice channel")
+	}
 
+	needed := permissionViewChannel | permissionConnect
+	if perms&needed != needed {
+		return "", errors.New("I am not allowed to join your voice channel")
 	}
 
-	go gw.open()
+	if perms&permissionSpeak == 0 {
+		return "", errors.New("I am not allowed to speak in your voice channel")
+	}
 
-	bufio.NewReader(os.Stdin).ReadBytes('\n')
+	return cID, nil
 }
 
-func ytdl() {
-	yID := "LDU_Txk06tM"
-	cmd := exec.Command("youtube-dl", "-o", "-", "-f 251", yID)
-	//cmd := exec.Command("youtube-dl", "-o", "-", "-f 251", yID, "|", "ffmpeg", "-i", "pipe:", "-f", "s16le", "-ar", "48000", "-ac", "2", "pipe:")
+// checkListening returns an error unless the user is in the voice channel
+// of the bot, so people who are not listening can not skip or stop.
+func checkListening(s *state, guildID, userID string) error {
+	botChannel, ok := s.voiceChannelOf(guildID, s.botUser().ID)
+	if !ok {
+		return nil
+	}
 
-	p, _ := os.Getwd()
-	f, err := os.Create(p + "/video.webm")
+	if cID, ok := s.voiceChannelOf(guildID, userID); !ok || cID != botChannel {
+		return errors.New("Join my voice channel first")
+	}
+	return nil
+}
 
-	if err != nil {
-		log.Fatal(fmt.Errorf("error creating file: %v", err))
+// connectVoice joins a voice channel unless the bot is already connected
+// to it, so tracks can be queued without reconnecting.
+func connectVoice(voices *voiceManager, guildID, channelID string) (*voice, error) {
+	if voi, ok := voices.get(guildID); ok && voi.running && voi.currentChannelID == channelID {
+		return voi, nil
 	}
-	defer f.Close()
-	//bw := bufio.NewWriter(f)
 
-	r, err := cmd.StdoutPipe()
+	voi, connected, err := voices.join(guildID, channelID)
 	if err != nil {
-		log.Fatal(fmt.Errorf("error getting StdoutPipe: %v", err))
+		return nil, err
 	}
 
-	err = cmd.Start()
+	err = <-connected
 	if err != nil {
-		log.Fatal(fmt.Errorf("error starting command: %v", err))
+		return nil, err
 	}
 
-	var totalBytes int64
-	buf := make([]byte, 0, 4096)
-	for {
-		// using buf[:cap(buf)] will make the slice use the whole underlying array of 4096 bytes
-		n, err := r.Read(buf[:cap(buf)])
-
-		// only use the read bytes of buf
-		// since the slice is between 0 and n the cap of buf will not change
-		buf = buf[:n]
-
-		// read will not return an error if n > 0
-		if n == 0 {
-			if err == nil {
-				// nothing happend
-				continue
-			}
-			if err == io.EOF {
-				break
-			} else {
-				log.Printf("read error: %v\n", err)
-			}
-		}
-		_, err = f.Write(buf)
+	go func() {
+		err := <-connected
 		if err != nil {
-			log.Fatal(fmt.Errorf("error writing to file: %v", err))
+			log.Printf("voice connection error: %v\n", err)
 		}
+	}()
 
-		totalBytes += int64(len(buf))
-	}
-
-	f.Sync()
-	log.Println("total bytes", totalBytes)
+	return voi, nil
 }
 
 func readToken() (string, error) {
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// messageSend is the body for creating or editing a message
+type messageSend struct {
+	Content          string            `json:"content,omitempty"`
+	Embeds           []embed           `json:"embeds,omitempty"`
+	AllowedMentions  *allowedMentions  `json:"allowed_mentions,omitempty"`
+	MessageReference *messageReference `json:"message_reference,omitempty"`
+	Attachments      []attachmentSend  `json:"attachments,omitempty"`
+	Flags            int               `json:"flags,omitempty"`
+	Components       []component       `json:"components,omitempty"`
+	// Files are uploaded as attachments of the message
+	Files []*messageFile `json:"-"`
+}
+
+// messageFlagEphemeral makes an interaction response only visible to the user
+const messageFlagEphemeral = 1 << 6
+
+// allowedMentions decides who is pinged by the mentions in a message,
+// an empty Parse pings nobody.
+type allowedMentions struct {
+	Parse       []string `json:"parse"` // "roles", "users" and "everyone"
+	Roles       []string `json:"roles,omitempty"`
+	Users       []string `json:"users,omitempty"`
+	RepliedUser bool     `json:"replied_user"`
+}
+
+// attachmentSend describes an uploaded file, the id is the index of the file
+type attachmentSend struct {
+	ID          int    `json:"id"`
+	Filename    string `json:"filename"`
+	Description string `json:"description,omitempty"`
+}
+
+// messageFile is a file uploaded with a message
+type messageFile struct {
+	Name   string
+	Reader io.Reader
+}
+
+// createMessage posts a message in a channel, files are sent as
+// multipart/form-data.
+func (c *restClient) createMessage(channelID string, m *messageSend) (*message, error) {
+	var msg message
+	err := c.sendMessageData("POST", "/channels/"+channelID+"/messages", m, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating message: %v", err)
+	}
+	return &msg, nil
+}
+
+// editMessage replaces the content, embeds, components or attachments of
+// a message. Empty fields are left out of the request, so they are left
+// unchanged, clearing a field is not supported.
+func (c *restClient) editMessage(channelID, messageID string, m *messageSend) (*message, error) {
+	var msg message
+	err := c.sendMessageData("PATCH", "/channels/"+channelID+"/messages/"+messageID, m, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("error editing message: %v", err)
+	}
+	return &msg, nil
+}
+
+func (c *restClient) deleteMessage(channelID, messageID string) error {
+	err := c.request("DELETE", "/channels/"+channelID+"/messages/"+messageID, nil, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting message: %v", err)
+	}
+	return nil
+}
+
+// bulkDeleteMessages deletes up to 100 messages at once, Discord refuses
+// to bulk delete messages that are more than two weeks old.
+func (c *restClient) bulkDeleteMessages(channelID string, messageIDs []string) error {
+	switch {
+	case len(messageIDs) == 0:
+		return nil
+	case len(messageIDs) == 1:
+		// bulk delete needs at least two messages
+		return c.deleteMessage(channelID, messageIDs[0])
+	case len(messageIDs) > 100:
+		return errors.New("can not bulk delete more than 100 messages")
+	}
+
+	body := struct {
+		Messages []string `json:"messages"`
+	}{messageIDs}
+
+	err := c.request("POST", "/channels/"+channelID+"/messages/bulk-delete", body, nil)
+	if err != nil {
+		return fmt.Errorf("error bulk deleting messages: %v", err)
+	}
+	return nil
+}
+
+// sendMessageData sends m as json, or as multipart/form-data with the
+// json in the payload_json field when it has files.
+func (c *restClient) sendMessageData(method, path string, m *messageSend, v interface{}) error {
+	if len(m.Files) == 0 {
+		return c.request(method, path, m, v)
+	}
+
+	contentType, body, err := multipartBody(attachFiles(m), m.Files)
+	if err != nil {
+		return err
+	}
+	return c.do(method, path, contentType, body, v)
+}
+
+// attachFiles returns a copy of m with an attachment for every file, the
+// attachments refer to the files by their index.
+func attachFiles(m *messageSend) *messageSend {
+	send := *m
+	send.Attachments = nil
+	for i, f := range m.Files {
+		send.Attachments = append(send.Attachments, attachmentSend{ID: i, Filename: f.Name})
+	}
+	return &send
+}
+
+// multipartBody writes payload as json in the payload_json field followed
+// by the files, it returns the content type and the body.
+func multipartBody(payload interface{}, files []*messageFile) (string, []byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("error marshalling message: %v", err)
+	}
+	err = w.WriteField("payload_json", string(jsonData))
+	if err != nil {
+		return "", nil, err
+	}
+
+	for i, f := range files {
+		part, err := w.CreateFormFile("files["+strconv.Itoa(i)+"]", f.Name)
+		if err != nil {
+			return "", nil, err
+		}
+		_, err = io.Copy(part, f.Reader)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading file %s: %v", f.Name, err)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	return w.FormDataContentType(), buf.Bytes(), nil
+}
+
+// nowPlayingEmbed shows a track together with how much of it is played.
+func nowPlayingEmbed(t *track, elapsed time.Duration) embed {
+	e := embed{
+		Title:       t.title,
+		Description: fmt.Sprintf("%s / %s", formatDuration(elapsed), formatDuration(t.duration)),
+		Author:      &embedAuthor{Name: "Now playing"},
+		Fields: []embedField{
+			{Name: "Duration", Value: formatDuration(t.duration), Inline: true},
+			{Name: "Requested by", Value: "<@" + t.requester + ">", Inline: true}}}
+
+	// local files have no page to link to
+	if strings.HasPrefix(t.url, "https://") {
+		e.URL = t.url
+	}
+	if t.thumbnail != "" {
+		e.Thumbnail = &embedImage{URL: t.thumbnail}
+	}
+	return e
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+const (
+	oggHeaderSize = 27
+	oggMaxSegment = 255
+
+	oggContinued = 0x01
+	oggBOS       = 0x02
+	oggEOS       = 0x04
+)
+
+// oggCRCTable is the table for the crc used by ogg, polynomial 0x04c11db7
+// without reflection or a final xor.
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+// opusHead is the identification header of an Ogg Opus stream
+type opusHead struct {
+	version       uint8
+	channels      uint8
+	preSkip       uint16
+	sampleRate    uint32
+	outputGain    int16
+	mappingFamily uint8
+}
+
+func parseOpusHead(p []byte) (opusHead, error) {
+	if len(p) < 19 || !bytes.HasPrefix(p, []byte("OpusHead")) {
+		return opusHead{}, errors.New("ogg stream does not start with an OpusHead")
+	}
+
+	return opusHead{
+		version:       p[8],
+		channels:      p[9],
+		preSkip:       binary.LittleEndian.Uint16(p[10:12]),
+		sampleRate:    binary.LittleEndian.Uint32(p[12:16]),
+		outputGain:    int16(binary.LittleEndian.Uint16(p[16:18])),
+		mappingFamily: p[18]}, nil
+}
+
+func (h opusHead) bytes() []byte {
+	b := make([]byte, 19)
+	copy(b, "OpusHead")
+	b[8] = h.version
+	b[9] = h.channels
+	binary.LittleEndian.PutUint16(b[10:12], h.preSkip)
+	binary.LittleEndian.PutUint32(b[12:16], h.sampleRate)
+	binary.LittleEndian.PutUint16(b[16:18], uint16(h.outputGain))
+	b[18] = h.mappingFamily
+	return b
+}
+
+// oggReader reads the opus packets from the first logical stream of an
+// Ogg Opus file, the OpusHead and OpusTags headers are read on creation.
+type oggReader struct {
+	r       *bufio.Reader
+	head    opusHead
+	serial  uint32
+	packets [][]byte
+	partial []byte
+	started bool
+	// position is the granule position at the end of the last packet read
+	position int64
+	// end is the granule position of the end of stream page, or -1
+	end int64
+}
+
+func newOggReader(r io.Reader) (*oggReader, error) {
+	o := oggReader{r: bufio.NewReader(r), end: -1}
+
+	p, err := o.readPacket()
+	if err != nil {
+		return nil, fmt.Errorf("error reading OpusHead: %v", err)
+	}
+	o.head, err = parseOpusHead(p)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err = o.readPacket()
+	if err != nil {
+		return nil, fmt.Errorf("error reading OpusTags: %v", err)
+	}
+	if !bytes.HasPrefix(p, []byte("OpusTags")) {
+		return nil, errors.New("ogg stream is missing OpusTags")
+	}
+
+	o.position = 0
+	return &o, nil
+}
+
+// readPacket returns the next opus packet.
+func (o *oggReader) readPacket() ([]byte, error) {
+	for len(o.packets) == 0 {
+		err := o.readPage()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := o.packets[0]
+	o.packets = o.packets[1:]
+
+	// invalid packets are left to the caller
+	samples, _ := opusPacketSamples(p)
+	o.position += int64(samples)
+	return p, nil
+}
+
+func (o *oggReader) readPage() error {
+	header := make([]byte, oggHeaderSize)
+	_, err := io.ReadFull(o.r, header)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(header[:4], []byte("OggS")) || header[4] != 0 {
+		return errors.New("invalid ogg page header")
+	}
+
+	flags := header[5]
+	granule := int64(binary.LittleEndian.Uint64(header[6:14]))
+	serial := binary.LittleEndian.Uint32(header[14:18])
+	crc := binary.LittleEndian.Uint32(header[22:26])
+
+	segments := make([]byte, header[26])
+	_, err = io.ReadFull(o.r, segments)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	size := 0
+	for _, s := range segments {
+		size += int(s)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(o.r, data)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	// the crc is calculated with the crc field set to zero
+	copy(header[22:26], []byte{0, 0, 0, 0})
+	page := append(append(header, segments...), data...)
+	if oggCRC(page) != crc {
+		return errors.New("ogg page has an invalid checksum")
+	}
+
+	// only the first logical stream is read
+	if !o.started {
+		o.serial = serial
+		o.started = true
+	}
+	if serial != o.serial {
+		return nil
+	}
+
+	if flags&oggContinued == 0 {
+		o.partial = nil
+	}
+
+	// a packet ends with a segment shorter than 255 bytes, the last
+	// packet may continue on the next page
+	for _, s := range segments {
+		o.partial = append(o.partial, data[:s]...)
+		data = data[s:]
+		if s < oggMaxSegment {
+			o.packets = append(o.packets, o.partial)
+			o.partial = nil
+		}
+	}
+
+	// the granule position is the end of the last packet finished on the
+	// page, except on the last page where it can cut off the padding
+	if flags&oggEOS != 0 {
+		o.end = granule
+	} else if granule != -1 && len(o.packets) > 0 {
+		o.position = granule
+		for _, p := range o.packets {
+			samples, _ := opusPacketSamples(p)
+			o.position -= int64(samples)
+		}
+	}
+
+	return nil
+}
+
+// oggWriter writes opus packets as an Ogg Opus stream, one page for
+// every packet.
+type oggWriter struct {
+	w       io.Writer
+	serial  uint32
+	seq     uint32
+	granule int64
+}
+
+// newOggWriter writes the OpusHead and OpusTags headers to w.
+func newOggWriter(w io.Writer, channels int) (*oggWriter, error) {
+	o := oggWriter{w: w, serial: rand.Uint32()}
+
+	// 312 samples is the pre-skip recommended for libopus encoders
+	head := opusHead{
+		version:    1,
+		channels:   uint8(channels),
+		preSkip:    312,
+		sampleRate: uint32(frameRate)}
+
+	err := o.writePage(head.bytes(), oggBOS, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error writing OpusHead: %v", err)
+	}
+
+	vendor := "go-bot"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:12], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+
+	err = o.writePage(tags, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error writing OpusTags: %v", err)
+	}
+
+	// the granule position includes the pre-skip
+	o.granule = int64(head.preSkip)
+	return &o, nil
+}
+
+// writePacket writes a page containing a single opus packet.
+func (o *oggWriter) writePacket(p []byte) error {
+	samples, err := opusPacketSamples(p)
+	if err != nil {
+		return err
+	}
+
+	o.granule += int64(samples)
+	return o.writePage(p, 0, o.granule)
+}
+
+// close ends the stream with an empty end of stream page.
+func (o *oggWriter) close() error {
+	return o.writePage(nil, oggEOS, o.granule)
+}
+
+func (o *oggWriter) writePage(p []byte, flags byte, granule int64) error {
+	if len(p) >= oggMaxSegment*oggMaxSegment {
+		return errors.New("opus packet is too large for an ogg page")
+	}
+
+	// a packet is split in 255 byte segments followed by a shorter
+	// segment, which is empty when the size is a multiple of 255
+	var segments []byte
+	if p != nil {
+		for n := len(p); ; n -= oggMaxSegment {
+			if n < oggMaxSegment {
+				segments = append(segments, byte(n))
+				break
+			}
+			segments = append(segments, oggMaxSegment)
+		}
+	}
+
+	page := make([]byte, oggHeaderSize, oggHeaderSize+len(segments)+len(p))
+	copy(page, "OggS")
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:18], o.serial)
+	binary.LittleEndian.PutUint32(page[18:22], o.seq)
+	page[26] = byte(len(segments))
+	page = append(append(page, segments...), p...)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+
+	o.seq++
+	_, err := o.w.Write(page)
+	return err
+}
+
+// opusPacketSamples returns the number of 48 kHz samples in an opus
+// packet, read from its table of contents byte.
+func opusPacketSamples(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, errors.New("empty opus packet")
+	}
+
+	// frame sizes in units of 2.5 ms
+	config := p[0] >> 3
+	var size int
+	switch {
+	case config < 12:
+		size = []int{4, 8, 16, 24}[config%4]
+	case config < 16:
+		size = []int{4, 8}[config%2]
+	default:
+		size = []int{1, 2, 4, 8}[config%4]
+	}
+
+	var frames int
+	switch p[0] & 0x03 {
+	case 0:
+		frames = 1
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(p) < 2 {
+			return 0, errors.New("opus packet is missing its frame count")
+		}
+		frames = int(p[1] & 0x3F)
+	}
+
+	// 2.5 ms is 120 samples at 48 kHz
+	return frames * size * 120, nil
+}
+
+// oggSource sends the packets of an Ogg Opus stream without re-encoding
+type oggSource struct {
+	demuxer *oggReader
+	// first is the packet read to check the frame size
+	first []byte
+}
+
+// newOggStreamSource reads Ogg Opus audio from in, such as a radio stream.
+func newOggStreamSource(in io.Reader) (*oggSource, error) {
+	demuxer, err := newOggReader(in)
+	if err != nil {
+		return nil, err
+	}
+
+	// other channel mappings can not be passed through
+	if demuxer.head.channels > 2 || demuxer.head.mappingFamily != 0 {
+		return nil, fmt.Errorf("ogg audio has %d channels, only mono and stereo can be passed through", demuxer.head.channels)
+	}
+
+	s := &oggSource{demuxer: demuxer}
+	s.first, err = s.readOpus()
+	if err != nil {
+		return nil, fmt.Errorf("error reading first ogg packet: %v", err)
+	}
+	return s, nil
+}
+
+// readOpus returns the next packet that is played. Packets within the
+// pre-skip or after the end of the stream are dropped, a packet that is
+// only partly in them can not be cut without re-encoding and is played.
+func (s *oggSource) readOpus() ([]byte, error) {
+	if s.first != nil {
+		packet := s.first
+		s.first = nil
+		return packet, nil
+	}
+
+	for {
+		packet, err := s.demuxer.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		err = checkFrameSize(packet)
+		if err != nil {
+			return nil, err
+		}
+
+		end := s.demuxer.position
+		if end <= int64(s.demuxer.head.preSkip) {
+			continue
+		}
+		if s.demuxer.end >= 0 && end-int64(frameSize) >= s.demuxer.end {
+			continue
+		}
+		return packet, nil
+	}
+}
+
+func (s *oggSource) close() error {
+	return nil
+}
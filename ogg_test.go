@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// oggStream writes packets with an oggWriter.
+func oggStream(t *testing.T, packets ...[]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := newOggWriter(&buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		err = w.writePacket(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// opusPacket returns a 20 ms packet with size bytes.
+func opusPacket(size int) []byte {
+	p := make([]byte, size)
+	if size > 0 {
+		p[0] = 31 << 3
+		for i := 1; i < size; i++ {
+			p[i] = byte(i)
+		}
+	}
+	return p
+}
+
+func TestOggRoundTrip(t *testing.T) {
+	// sizes below, at and above the 255 byte segment size
+	packets := [][]byte{opusPacket(254), opusPacket(255), opusPacket(600), opusPacket(510)}
+
+	r, err := newOggReader(bytes.NewReader(oggStream(t, packets...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.head.channels != 2 || r.head.sampleRate != uint32(frameRate) || r.head.preSkip != 312 {
+		t.Errorf("got OpusHead %+v", r.head)
+	}
+
+	for i, want := range packets {
+		p, err := r.readPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !bytes.Equal(p, want) {
+			t.Errorf("packet %d: got %d bytes, want %d", i, len(p), len(want))
+		}
+	}
+
+	// the end of stream page has no packets
+	_, err = r.readPacket()
+	if err == nil {
+		t.Error("reading after the last packet should fail")
+	}
+}
+
+func TestOggEmptyPacket(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newOggWriter(&buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty packet has no toc byte to take the duration from
+	err = w.writePacket(nil)
+	if err == nil {
+		t.Error("an empty packet should not be written as audio")
+	}
+
+	// written as a page it is a single empty segment
+	err = w.writePage([]byte{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.writePacket(opusPacket(3))
+
+	r, err := newOggReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][]byte{{}, opusPacket(3)} {
+		p, err := r.readPacket()
+		if err != nil || !bytes.Equal(p, want) {
+			t.Errorf("packet %d: got %x, %v, want %x", i, p, err, want)
+		}
+	}
+}
+
+func TestOggChecksum(t *testing.T) {
+	b := oggStream(t, opusPacket(10))
+	b[len(b)-40] ^= 0xFF
+
+	r, err := newOggReader(bytes.NewReader(b))
+	if err == nil {
+		_, err = r.readPacket()
+	}
+	if err == nil {
+		t.Error("a corrupted page should fail the checksum")
+	}
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	tests := []struct {
+		packet  []byte
+		samples int
+	}{
+		{[]byte{31 << 3}, 960},       // CELT 20 ms
+		{[]byte{28 << 3}, 120},       // CELT 2.5 ms
+		{[]byte{1 << 3}, 960},        // SILK 20 ms
+		{[]byte{3 << 3}, 2880},       // SILK 60 ms
+		{[]byte{15 << 3}, 960},       // hybrid 20 ms
+		{[]byte{31<<3 | 1}, 1920},    // two frames
+		{[]byte{31<<3 | 3, 3}, 2880}, // three frames
+	}
+
+	for _, test := range tests {
+		samples, err := opusPacketSamples(test.packet)
+		if err != nil || samples != test.samples {
+			t.Errorf("opusPacketSamples(%x) = %d, %v, want %d", test.packet, samples, err, test.samples)
+		}
+	}
+
+	for _, bad := range [][]byte{nil, {31<<3 | 3}} {
+		if _, err := opusPacketSamples(bad); err == nil {
+			t.Errorf("opusPacketSamples(%x) should fail", bad)
+		}
+	}
+}
+
+func TestOggSourceFrameSize(t *testing.T) {
+	packet20 := opusPacket(4)
+	src, err := newOggStreamSource(bytes.NewReader(oggStream(t, packet20, packet20)))
+	if err != nil {
+		t.Fatalf("20 ms packets should be passed through: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		p, err := src.readOpus()
+		if err != nil || !bytes.Equal(p, packet20) {
+			t.Errorf("packet %d: got %x, %v", i, p, err)
+		}
+	}
+
+	packet60 := []byte{3 << 3, 1, 2, 3}
+	_, err = newOggStreamSource(bytes.NewReader(oggStream(t, packet60)))
+	if err == nil {
+		t.Error("60 ms packets should not be passed through")
+	}
+}
+
+func TestOggSourceLaterFrameSize(t *testing.T) {
+	src, err := newOggStreamSource(bytes.NewReader(oggStream(t, opusPacket(4), []byte{3 << 3, 1, 2, 3})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.readOpus()
+	if _, err := src.readOpus(); err == nil {
+		t.Error("a 60 ms packet after the first should fail")
+	}
+}
+
+func TestOggSourceTrim(t *testing.T) {
+	var buf bytes.Buffer
+	w := &oggWriter{w: &buf}
+	w.writePage(opusHead{version: 1, channels: 2, preSkip: 1920, sampleRate: uint32(frameRate)}.bytes(), oggBOS, 0)
+	w.writePage([]byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00"), 0, 0)
+
+	// the first two packets are in the pre-skip, the last page ends
+	// before its packet
+	var packets [][]byte
+	for i := 0; i < 5; i++ {
+		packets = append(packets, opusPacket(10+i))
+	}
+	for i, p := range packets[:4] {
+		w.writePage(p, 0, int64((i+1)*frameSize))
+	}
+	w.writePage(packets[4], oggEOS, int64(4*frameSize))
+
+	src, err := newOggStreamSource(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range packets[2:4] {
+		p, err := src.readOpus()
+		if err != nil || !bytes.Equal(p, want) {
+			t.Fatalf("got %d bytes, %v, want packet of %d bytes", len(p), err, len(want))
+		}
+	}
+	if p, err := src.readOpus(); err == nil {
+		t.Errorf("got %d bytes, the padding at the end should be dropped", len(p))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Permission bits, see https://discord.com/developers/docs/topics/permissions
+const (
+	permissionAdministrator int64 = 1 << 3
+	permissionManageGuild   int64 = 1 << 5
+	permissionViewChannel   int64 = 1 << 10
+	permissionSendMessages  int64 = 1 << 11
+	permissionConnect       int64 = 1 << 20
+	permissionSpeak         int64 = 1 << 21
+	permissionAll           int64 = 1<<63 - 1
+)
+
+// channelPermissions computes the permissions of a user in a guild channel
+// from the roles of the user and the permission overwrites of the channel.
+func (s *state) channelPermissions(channelID, userID string) (int64, error) {
+	return s.memberPermissions(channelID, userID, nil)
+}
+
+// memberPermissions is channelPermissions with the roles taken from m, for
+// members that are not cached such as the author of a message. The cached
+// member is used when m is nil.
+func (s *state) memberPermissions(channelID, userID string, m *member) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.channels[channelID]
+	if !ok {
+		return 0, fmt.Errorf("unknown channel %s", channelID)
+	}
+
+	gs, ok := s.guilds[c.GuildID]
+	if !ok {
+		return 0, fmt.Errorf("unknown guild %s", c.GuildID)
+	}
+
+	if gs.OwnerID == userID {
+		return permissionAll, nil
+	}
+
+	if m == nil {
+		cached, ok := gs.members[userID]
+		if !ok {
+			return 0, fmt.Errorf("user %s is not a cached member of guild %s", userID, c.GuildID)
+		}
+		m = &cached
+	}
+
+	// the @everyone role has the same id as the guild
+	perms := parsePermissions(gs.roles[gs.ID].Permissions)
+	for _, id := range m.Roles {
+		perms |= parsePermissions(gs.roles[id].Permissions)
+	}
+
+	if perms&permissionAdministrator != 0 {
+		return permissionAll, nil
+	}
+
+	// overwrites are applied for @everyone, then roles and last the member
+	var roleAllow, roleDeny int64
+	var memberOverwrite *permissionOverwrite
+	for i, o := range c.PermissionOverwrites {
+		switch {
+		case o.ID == gs.ID:
+			perms &^= parsePermissions(o.Deny)
+			perms |= parsePermissions(o.Allow)
+		case o.Type == 0 && hasRole(*m, o.ID):
+			roleDeny |= parsePermissions(o.Deny)
+			roleAllow |= parsePermissions(o.Allow)
+		case o.Type == 1 && o.ID == userID:
+			memberOverwrite = &c.PermissionOverwrites[i]
+		}
+	}
+
+	perms &^= roleDeny
+	perms |= roleAllow
+
+	if memberOverwrite != nil {
+		perms &^= parsePermissions(memberOverwrite.Deny)
+		perms |= parsePermissions(memberOverwrite.Allow)
+	}
+
+	return perms, nil
+}
+
+func hasRole(m member, roleID string) bool {
+	for _, id := range m.Roles {
+		if id == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePermissions parses the permission bit set that Discord
+// sends as a string, invalid values gives no permissions.
+func parsePermissions(p string) int64 {
+	v, err := strconv.ParseInt(p, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
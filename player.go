@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// player plays the tracks of a guild's queue one after another on the
+// voice connection of the guild.
+type player struct {
+	mu      sync.Mutex
+	voice   *voice
+	queue   *queue
+	current *track
+	frames  int
+	paused  bool
+	loop    bool
+
+	wake      chan struct{}
+	skipc     chan struct{}
+	resumec   chan struct{}
+	closec    chan struct{}
+	closeOnce sync.Once
+}
+
+// newPlayer starts a player with an empty queue.
+func newPlayer(v *voice) *player {
+	p := player{
+		voice:   v,
+		queue:   newQueue(),
+		wake:    make(chan struct{}, 1),
+		skipc:   make(chan struct{}, 1),
+		resumec: make(chan struct{}),
+		closec:  make(chan struct{})}
+
+	go p.run()
+	return &p
+}
+
+// enqueue adds a track to the queue, it starts playing right
+// away when nothing else is playing.
+func (p *player) enqueue(t *track) int {
+	i := p.queue.enqueue(t)
+	signal(p.wake)
+	return i
+}
+
+// run pulls the next track from the queue when the current one
+// ends, until the player is closed.
+func (p *player) run() {
+	var t *track
+	for {
+		if t == nil {
+			var ok bool
+			t, ok = p.queue.dequeue()
+			if !ok {
+				select {
+				case <-p.wake:
+					continue
+				case <-p.closec:
+					return
+				}
+			}
+		}
+
+		finished, err := p.play(t)
+		if errors.Is(err, errVoiceClosed) {
+			p.close()
+			return
+		}
+		if err != nil {
+			log.Printf("error playing %s: %v\n", t.title, err)
+		}
+
+		// a looping track is played again unless it was skipped
+		if !finished || !p.looping() {
+			t = nil
+		}
+
+		select {
+		case <-p.closec:
+			return
+		default:
+		}
+	}
+}
+
+// play sends the audio of a track until it ends or is skipped, finished
+// is only true when the whole track was played.
+func (p *player) play(t *track) (finished bool, err error) {
+	p.mu.Lock()
+	p.current = t
+	p.frames = 0
+	p.paused = false
+	// a skip sent after the previous track ended is not for this track
+	select {
+	case <-p.skipc:
+	default:
+	}
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.current = nil
+		p.paused = false
+		p.mu.Unlock()
+	}()
+
+	// the track is current while it loads, a skip meanwhile is handled
+	// before the first frame is sent
+	src, err := openTrack(t)
+	if err != nil {
+		return false, err
+	}
+	defer src.close()
+
+	p.voice.speaking(true)
+	defer p.voice.speaking(false)
+
+	for {
+		select {
+		case <-p.skipc:
+			return false, nil
+		case <-p.closec:
+			return false, nil
+		default:
+		}
+
+		p.mu.Lock()
+		paused, resumec := p.paused, p.resumec
+		p.mu.Unlock()
+
+		if paused {
+			p.voice.speaking(false)
+			select {
+			case <-resumec:
+			case <-p.skipc:
+				return false, nil
+			case <-p.closec:
+				return false, nil
+			}
+			p.voice.speaking(true)
+		}
+
+		opus, err := src.readOpus()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error reading audio: %v", err)
+		}
+
+		err = p.voice.sendOpusData(opus)
+		if err != nil {
+			return false, err
+		}
+
+		p.mu.Lock()
+		p.frames++
+		p.mu.Unlock()
+	}
+}
+
+// nowPlaying returns the playing track and how much of it has been played.
+func (p *player) nowPlaying() (*track, time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == nil {
+		return nil, 0, false
+	}
+
+	// every opus frame is 20 ms of audio
+	return p.current, time.Duration(p.frames) * 20 * time.Millisecond, true
+}
+
+// skip stops the playing track, the next track in the queue starts
+// playing. It reports false when nothing is playing.
+func (p *player) skip() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == nil {
+		return false
+	}
+
+	signal(p.skipc)
+	return true
+}
+
+// stop clears the queue and stops the playing track.
+func (p *player) stop() {
+	p.queue.clear()
+	p.skip()
+}
+
+// pause pauses the playing track, it reports false when
+// nothing is playing or the track is already paused.
+func (p *player) pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == nil || p.paused {
+		return false
+	}
+
+	p.paused = true
+	return true
+}
+
+// resume continues a paused track, it reports false when
+// nothing is paused.
+func (p *player) resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return false
+	}
+
+	p.paused = false
+	close(p.resumec)
+	p.resumec = make(chan struct{})
+	return true
+}
+
+// setLoop makes the playing track repeat until it is skipped.
+func (p *player) setLoop(loop bool) {
+	p.mu.Lock()
+	p.loop = loop
+	p.mu.Unlock()
+}
+
+func (p *player) looping() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.loop
+}
+
+func (p *player) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.paused
+}
+
+// jump plays the queued track with the url right away, the tracks before
+// it stay in the queue.
+func (p *player) jump(url string) (*track, error) {
+	t, err := p.queue.moveToFront(url)
+	if err != nil {
+		return nil, err
+	}
+
+	p.skip()
+	return t, nil
+}
+
+// close stops the player for good, it is used when leaving voice.
+func (p *player) close() {
+	p.closeOnce.Do(func() {
+		close(p.closec)
+	})
+}
+
+func (p *player) closed() bool {
+	select {
+	case <-p.closec:
+		return true
+	default:
+		return false
+	}
+}
+
+// signal wakes up a goroutine waiting on c without blocking, c
+// needs a buffer of one.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// openTrack starts the audio of a track, local ogg files are read
+// directly and everything else is streamed with yt-dlp.
+func openTrack(t *track) (audioSource, error) {
+	switch strings.ToLower(filepath.Ext(t.url)) {
+	case ".ogg", ".opus":
+		if _, err := os.Stat(t.url); err == nil {
+			return newFileSource(t.url)
+		}
+	}
+
+	return newYtdlSource(t.url)
+}
+
+// playerManager keeps the player of every guild.
+type playerManager struct {
+	mu      sync.Mutex
+	players map[string]*player
+}
+
+func newPlayerManager() *playerManager {
+	return &playerManager{players: make(map[string]*player)}
+}
+
+// forGuild returns the player of a guild, a new player is started if
+// the guild has none or its player stopped with the voice connection.
+func (pm *playerManager) forGuild(guildID string, v *voice) *player {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	p, ok := pm.players[guildID]
+	if !ok || p.closed() || p.voice != v {
+		if ok {
+			p.close()
+		}
+		p = newPlayer(v)
+		pm.players[guildID] = p
+	}
+	return p
+}
+
+// get returns the running player of a guild.
+func (pm *playerManager) get(guildID string) (*player, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	p, ok := pm.players[guildID]
+	if !ok || p.closed() {
+		return nil, false
+	}
+	return p, true
+}
+
+// remove closes and forgets the player of a guild.
+func (pm *playerManager) remove(guildID string) {
+	pm.mu.Lock()
+	p, ok := pm.players[guildID]
+	delete(pm.players, guildID)
+	pm.mu.Unlock()
+
+	if ok {
+		p.close()
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// track is a song in a queue
+type track struct {
+	title     string
+	url       string // video id, url or path of a local file
+	duration  time.Duration
+	requester string // id of the user that requested the track
+	thumbnail string
+}
+
+// queue holds the tracks waiting to be played in a guild, indexes
+// start at 0 with the track that plays next.
+type queue struct {
+	mu     sync.Mutex
+	tracks []*track
+}
+
+func newQueue() *queue {
+	return &queue{}
+}
+
+// enqueue adds a track to the end of the queue and returns its index.
+func (q *queue) enqueue(t *track) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.tracks = append(q.tracks, t)
+	return len(q.tracks) - 1
+}
+
+// dequeue removes and returns the next track.
+func (q *queue) dequeue() (*track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.tracks) == 0 {
+		return nil, false
+	}
+
+	t := q.tracks[0]
+	q.tracks[0] = nil
+	q.tracks = q.tracks[1:]
+	return t, true
+}
+
+// peek returns the next track without removing it.
+func (q *queue) peek() (*track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.tracks) == 0 {
+		return nil, false
+	}
+	return q.tracks[0], true
+}
+
+// remove removes the track at index i.
+func (q *queue) remove(i int) (*track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i < 0 || i >= len(q.tracks) {
+		return nil, fmt.Errorf("queue has no track at index %d", i)
+	}
+
+	t := q.tracks[i]
+	q.tracks = append(q.tracks[:i], q.tracks[i+1:]...)
+	return t, nil
+}
+
+// move moves the track at index from to index to, the tracks
+// in between are shifted by one.
+func (q *queue) move(from, to int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if from < 0 || from >= len(q.tracks) {
+		return fmt.Errorf("queue has no track at index %d", from)
+	}
+	if to < 0 || to >= len(q.tracks) {
+		return fmt.Errorf("queue has no track at index %d", to)
+	}
+
+	t := q.tracks[from]
+	if from < to {
+		copy(q.tracks[from:to], q.tracks[from+1:to+1])
+	} else {
+		copy(q.tracks[to+1:from+1], q.tracks[to:from])
+	}
+	q.tracks[to] = t
+	return nil
+}
+
+// moveToFront moves the first track with the url to the front of the
+// queue and returns it, in one step so the queue can not change in between.
+func (q *queue) moveToFront(url string) (*track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, t := range q.tracks {
+		if t.url == url {
+			copy(q.tracks[1:i+1], q.tracks[:i])
+			q.tracks[0] = t
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("queue has no track %s", url)
+}
+
+// clear removes every track and returns how many were removed.
+func (q *queue) clear() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := len(q.tracks)
+	q.tracks = nil
+	return n
+}
+
+func (q *queue) shuffle() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rand.Shuffle(len(q.tracks), func(i, j int) {
+		q.tracks[i], q.tracks[j] = q.tracks[j], q.tracks[i]
+	})
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.tracks)
+}
+
+// duration returns the total duration of the queued tracks.
+func (q *queue) duration() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var d time.Duration
+	for _, t := range q.tracks {
+		d += t.duration
+	}
+	return d
+}
+
+// list returns a copy of the queued tracks.
+func (q *queue) list() []*track {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]*track(nil), q.tracks...)
+}
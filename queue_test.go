@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testQueue returns a queue with a track for every letter of names, the
+// letter is used as title and url. The tracks are as many seconds long as
+// their position in the queue.
+func testQueue(names string) *queue {
+	q := newQueue()
+	for i, name := range names {
+		q.enqueue(&track{title: string(name), url: string(name), duration: time.Duration(i+1) * time.Second})
+	}
+	return q
+}
+
+// titles returns the titles of the queued tracks as one string.
+func titles(q *queue) string {
+	var s string
+	for _, t := range q.list() {
+		s += t.title
+	}
+	return s
+}
+
+func TestQueueRemove(t *testing.T) {
+	tests := []struct {
+		index int
+		title string
+		left  string
+	}{
+		{0, "a", "bcd"},
+		{3, "d", "abc"},
+		{1, "b", "acd"},
+	}
+
+	for _, test := range tests {
+		q := testQueue("abcd")
+		tr, err := q.remove(test.index)
+		if err != nil {
+			t.Errorf("remove(%d): %v", test.index, err)
+			continue
+		}
+		if tr.title != test.title || titles(q) != test.left {
+			t.Errorf("remove(%d) = %s leaving %s, want %s leaving %s", test.index, tr.title, titles(q), test.title, test.left)
+		}
+	}
+
+	for _, i := range []int{-1, 4} {
+		q := testQueue("abcd")
+		if _, err := q.remove(i); err == nil || titles(q) != "abcd" {
+			t.Errorf("remove(%d) should fail and leave the queue as it is, got %s", i, titles(q))
+		}
+	}
+
+	if _, err := newQueue().remove(0); err == nil {
+		t.Error("removing from an empty queue should fail")
+	}
+}
+
+func TestQueueMove(t *testing.T) {
+	tests := []struct {
+		from, to int
+		want     string
+	}{
+		{0, 3, "bcda"},
+		{3, 0, "dabc"},
+		{1, 2, "acbd"},
+		{2, 1, "acbd"},
+		{2, 2, "abcd"},
+	}
+
+	for _, test := range tests {
+		q := testQueue("abcd")
+		err := q.move(test.from, test.to)
+		if err != nil || titles(q) != test.want {
+			t.Errorf("move(%d, %d) = %s, %v, want %s", test.from, test.to, titles(q), err, test.want)
+		}
+	}
+
+	for _, bad := range [][2]int{{-1, 0}, {0, -1}, {4, 0}, {0, 4}} {
+		q := testQueue("abcd")
+		if err := q.move(bad[0], bad[1]); err == nil || titles(q) != "abcd" {
+			t.Errorf("move(%d, %d) should fail and leave the queue as it is, got %s", bad[0], bad[1], titles(q))
+		}
+	}
+}
+
+func TestQueueMoveToFront(t *testing.T) {
+	for _, want := range []string{"abcd", "bacd", "cabd", "dabc"} {
+		q := testQueue("abcd")
+		tr, err := q.moveToFront(want[:1])
+		if err != nil || tr.title != want[:1] || titles(q) != want {
+			t.Errorf("moveToFront(%s) = %v, %v leaving %s, want %s", want[:1], tr, err, titles(q), want)
+		}
+	}
+
+	// the first track with the url is moved
+	q := testQueue("abcb")
+	tr, err := q.moveToFront("b")
+	if err != nil || tr != q.list()[0] || titles(q) != "bacb" || q.list()[0].duration != 2*time.Second {
+		t.Errorf("moveToFront(b) = %v, %v leaving %s, want the first b", tr, err, titles(q))
+	}
+
+	if _, err := testQueue("abcd").moveToFront("e"); err == nil {
+		t.Error("moving a track that is not queued should fail")
+	}
+}
+
+func TestQueueClear(t *testing.T) {
+	q := testQueue("abc")
+	if n := q.clear(); n != 3 || q.len() != 0 {
+		t.Errorf("clear() = %d leaving %d tracks, want 3 leaving 0", n, q.len())
+	}
+	if n := q.clear(); n != 0 {
+		t.Errorf("clearing an empty queue = %d, want 0", n)
+	}
+
+	// the queue can be used again after it was cleared
+	q.enqueue(&track{title: "d"})
+	if titles(q) != "d" {
+		t.Errorf("got %s after clearing, want d", titles(q))
+	}
+}
+
+func TestQueueDuration(t *testing.T) {
+	q := newQueue()
+	if d := q.duration(); d != 0 {
+		t.Errorf("empty queue has duration %v", d)
+	}
+
+	q = testQueue("abc")
+	if d := q.duration(); d != 6*time.Second {
+		t.Errorf("duration() = %v, want 6s", d)
+	}
+
+	q.dequeue()
+	q.remove(1)
+	if d := q.duration(); d != 2*time.Second {
+		t.Errorf("duration() = %v after removing tracks, want 2s", d)
+	}
+
+	q.dequeue()
+	if d := q.duration(); d != 0 {
+		t.Errorf("duration() = %v after emptying the queue, want 0", d)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that allows limit events per period,
+// the bucket refills continuously so bursts are spread out over time.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  float64
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit int, per time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  float64(limit),
+		rate:   float64(limit) / per.Seconds(),
+		tokens: float64(limit),
+		last:   time.Now()}
+}
+
+// wait blocks until a token is available and takes it.
+func (r *rateLimiter) wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refill()
+	if r.tokens < 1 {
+		delay := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+		time.Sleep(delay)
+		r.refill()
+	}
+
+	r.tokens--
+}
+
+// reset fills the bucket, used when a new connection gets a fresh limit.
+func (r *rateLimiter) reset() {
+	r.mu.Lock()
+	r.tokens = r.limit
+	r.last = time.Now()
+	r.mu.Unlock()
+}
+
+func (r *rateLimiter) refill() {
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.limit {
+		r.tokens = r.limit
+	}
+	r.last = now
+}
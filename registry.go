@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// applicationCommand is a slash command as registered with Discord
+type applicationCommand struct {
+	ID            string          `json:"id,omitempty"`
+	ApplicationID string          `json:"application_id,omitempty"`
+	Version       string          `json:"version,omitempty"`
+	Type          int             `json:"type,omitempty"` // 1 for slash commands
+	Name          string          `json:"name"`
+	Description   string          `json:"description"`
+	Options       []commandOption `json:"options,omitempty"`
+}
+
+type commandOption struct {
+	Type         int             `json:"type"`
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Required     bool            `json:"required,omitempty"`
+	Choices      []commandChoice `json:"choices,omitempty"`
+	Autocomplete bool            `json:"autocomplete,omitempty"`
+	MinValue     *float64        `json:"min_value,omitempty"`
+	MaxValue     *float64        `json:"max_value,omitempty"`
+	Options      []commandOption `json:"options,omitempty"`
+}
+
+type commandChoice struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// commandHandler runs a slash command, it has to respond using ctx
+type commandHandler func(ctx *interactionContext)
+
+type registeredCommand struct {
+	command      applicationCommand
+	handler      commandHandler
+	autocomplete autocompleteHandler
+}
+
+// commandRegistry keeps the slash commands of the bot and their handlers,
+// together with the handlers of the components on messages sent by the bot.
+// The commands are written to Discord when the bot connects, but only
+// if they are different from the commands Discord already has.
+type commandRegistry struct {
+	mu         sync.Mutex
+	rest       *restClient
+	appID      string
+	global     map[string]*registeredCommand
+	guilds     map[string]map[string]*registeredCommand
+	components map[string]*registeredComponent
+	syncOnce   sync.Once
+}
+
+func newCommandRegistry(rest *restClient) *commandRegistry {
+	return &commandRegistry{
+		rest:       rest,
+		global:     make(map[string]*registeredCommand),
+		guilds:     make(map[string]map[string]*registeredCommand),
+		components: make(map[string]*registeredComponent)}
+}
+
+// register adds a global command.
+func (r *commandRegistry) register(cmd applicationCommand, handler commandHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.global[cmd.Name] = &registeredCommand{command: cmd, handler: handler}
+}
+
+// registerGuild adds a command that is only available in one guild,
+// guild commands are updated right away which is useful when testing.
+func (r *commandRegistry) registerGuild(guildID string, cmd applicationCommand, handler commandHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.guilds[guildID] == nil {
+		r.guilds[guildID] = make(map[string]*registeredCommand)
+	}
+	r.guilds[guildID][cmd.Name] = &registeredCommand{command: cmd, handler: handler}
+}
+
+// listen syncs the commands with Discord on the first READY and handles
+// the interactions received on the gateway.
+func (r *commandRegistry) listen(events *dispatcher) {
+	events.onReady(func(rd *ready) {
+		r.mu.Lock()
+		r.appID = rd.Application.ID
+		r.mu.Unlock()
+
+		r.syncOnce.Do(func() {
+			err := r.sync()
+			if err != nil {
+				log.Printf("error syncing slash commands: %v\n", err)
+			}
+		})
+	})
+
+	events.onInteractionCreate(func(i *interaction) {
+		r.handle(i, gatewayResponder(r.rest, i))
+	})
+}
+
+// handle runs the handler of an interaction, respond is used for the
+// first response.
+func (r *commandRegistry) handle(i *interaction, respond func(*interactionResponse) error) {
+	ctx := &interactionContext{rest: r.rest, interaction: i, respond: respond}
+
+	switch i.Type {
+	case interactionPing:
+		err := ctx.sendResponse(&interactionResponse{Type: responsePong})
+		if err != nil {
+			log.Printf("error answering ping: %v\n", err)
+		}
+
+	case interactionApplicationCommand:
+		c, ok := r.command(i.GuildID, i.Data.Name)
+		if !ok {
+			log.Printf("received unknown command %q\n", i.Data.Name)
+			err := ctx.reply(&messageSend{Content: "Unknown command", Flags: messageFlagEphemeral})
+			if err != nil {
+				log.Printf("error replying to unknown command: %v\n", err)
+			}
+			return
+		}
+		c.handler(ctx)
+
+	case interactionMessageComponent:
+		r.handleComponentInteraction(ctx)
+
+	case interactionAutocomplete:
+		r.handleAutocompleteInteraction(ctx)
+
+	default:
+		log.Printf("unhandled interaction type %d\n", i.Type)
+	}
+}
+
+// command finds a command by name, guild commands are preferred over
+// global commands with the same name.
+func (r *commandRegistry) command(guildID, name string) (*registeredCommand, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.guilds[guildID][name]; ok {
+		return c, true
+	}
+	c, ok := r.global[name]
+	return c, ok
+}
+
+// sync overwrites the global and guild commands registered with Discord.
+func (r *commandRegistry) sync() error {
+	r.mu.Lock()
+	appID := r.appID
+	global := commandList(r.global)
+	guilds := make(map[string][]applicationCommand)
+	for id, cmds := range r.guilds {
+		guilds[id] = commandList(cmds)
+	}
+	r.mu.Unlock()
+
+	if appID == "" {
+		return fmt.Errorf("application id is not known yet")
+	}
+
+	err := r.overwrite("/applications/"+appID+"/commands", global)
+	if err != nil {
+		return err
+	}
+
+	for guildID, cmds := range guilds {
+		err := r.overwrite("/applications/"+appID+"/guilds/"+guildID+"/commands", cmds)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// overwrite replaces the commands at path, nothing is written when
+// Discord already has the same commands.
+func (r *commandRegistry) overwrite(path string, cmds []applicationCommand) error {
+	var existing []applicationCommand
+	err := r.rest.request("GET", path, nil, &existing)
+	if err != nil {
+		return fmt.Errorf("error getting commands: %v", err)
+	}
+
+	if sameCommands(existing, cmds) {
+		log.Printf("commands at %s are up to date\n", path)
+		return nil
+	}
+
+	err = r.rest.request("PUT", path, cmds, nil)
+	if err != nil {
+		return fmt.Errorf("error overwriting commands: %v", err)
+	}
+
+	log.Printf("overwrote %d commands at %s\n", len(cmds), path)
+	return nil
+}
+
+func commandList(cmds map[string]*registeredCommand) []applicationCommand {
+	list := make([]applicationCommand, 0, len(cmds))
+	for _, c := range cmds {
+		list = append(list, c.command)
+	}
+	return list
+}
+
+// sameCommands compares commands without the fields set by Discord.
+func sameCommands(a, b []applicationCommand) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	return bytes.Equal(normalizeCommands(a), normalizeCommands(b))
+}
+
+func normalizeCommands(cmds []applicationCommand) []byte {
+	normalized := make([]applicationCommand, len(cmds))
+	for i, c := range cmds {
+		c.ID = ""
+		c.ApplicationID = ""
+		c.Version = ""
+		if c.Type == 0 {
+			c.Type = 1
+		}
+		normalized[i] = c
+	}
+
+	sort.Slice(normalized, func(i, j int) bool {
+		return normalized[i].Name < normalized[j].Name
+	})
+
+	b, _ := json.Marshal(normalized)
+	return b
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	restBaseURL   = "https://discord.com/api/v10"
+	restUserAgent = "DiscordBot (GoBot, 1.0)"
+	// restMaxRetries is how many times a rate limited or failed request is retried
+	restMaxRetries = 3
+)
+
+// restRetry decides how failed requests are retried. Requests that may
+// have reached Discord are only sent again when that is safe, a POST that
+// timed out could have created a message already.
+type restRetry struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// backoff returns the delay before retry number n, it doubles for every
+// retry up to maxDelay.
+func (r restRetry) backoff(n int) time.Duration {
+	if n < 32 && r.baseDelay<<uint(n-1) < r.maxDelay {
+		return r.baseDelay << uint(n-1)
+	}
+	return r.maxDelay
+}
+
+// retryable tells if a request that failed without a response can be sent
+// again, only methods that are idempotent are retried.
+func (r restRetry) retryable(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// restClient sends requests to the Discord HTTP api. Requests are queued
+// per rate limit bucket so the limits sent in the X-RateLimit headers are
+// never exceeded, and the bot does not get banned for ignoring 429s.
+type restClient struct {
+	token     string
+	baseURL   string
+	userAgent string
+	client    *http.Client
+	retry     restRetry
+
+	mu          sync.Mutex
+	buckets     map[string]*restBucket
+	routes      map[string]string // route to the bucket hash sent by Discord
+	globalUntil time.Time
+}
+
+// restBucket is a rate limit bucket, its lock is held while a request
+// in the bucket is sent so requests in the same bucket wait in line.
+type restBucket struct {
+	mu        sync.Mutex
+	remaining int
+	resetAt   time.Time
+}
+
+// restError is returned for responses that are not successful
+type restError struct {
+	Status  int    `json:"-"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s (code %d)", e.Status, e.Message, e.Code)
+}
+
+func newRestClient(token string) *restClient {
+	return &restClient{
+		token:     token,
+		baseURL:   restBaseURL,
+		userAgent: restUserAgent,
+		client:    &http.Client{Timeout: time.Second * 30},
+		retry:     restRetry{restMaxRetries, time.Second, time.Second * 10},
+		buckets:   make(map[string]*restBucket),
+		routes:    make(map[string]string)}
+}
+
+// request sends body as json and decodes the response into v, body
+// and v can be nil.
+func (c *restClient) request(method, path string, body, v interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshalling request body: %v", err)
+		}
+	}
+
+	return c.do(method, path, "application/json", data, v)
+}
+
+// do sends a request, waiting for its rate limit bucket first. Rate
+// limited requests and server errors are retried, requests that failed
+// without a response only when they are idempotent.
+func (c *restClient) do(method, path, contentType string, body []byte, v interface{}) error {
+	route, major := routeKey(method, path)
+
+	for attempt := 1; ; attempt++ {
+		b := c.bucket(route, major)
+
+		b.mu.Lock()
+		b.wait()
+		c.waitGlobal()
+		resp, err := c.send(method, path, contentType, body)
+		if err == nil {
+			c.update(route, major, b, resp)
+		}
+		b.mu.Unlock()
+
+		if err != nil {
+			if attempt > c.retry.maxRetries || !c.retry.retryable(method) {
+				return err
+			}
+			time.Sleep(c.retry.backoff(attempt))
+			continue
+		}
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error reading response of %s %s: %v", method, path, err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			c.rateLimited(b, resp, respBody)
+			if attempt > c.retry.maxRetries {
+				return fmt.Errorf("%s %s is still rate limited after %d attempts", method, path, attempt)
+			}
+			continue
+
+		case resp.StatusCode >= 500:
+			if attempt > c.retry.maxRetries {
+				return fmt.Errorf("%s %s failed with status %s", method, path, resp.Status)
+			}
+			time.Sleep(c.retry.backoff(attempt))
+			continue
+
+		case resp.StatusCode >= 300:
+			e := restError{Status: resp.StatusCode}
+			json.Unmarshal(respBody, &e)
+			if e.Message == "" {
+				e.Message = string(respBody)
+			}
+			return &e
+		}
+
+		if v == nil || resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+
+		err = json.Unmarshal(respBody, v)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling response of %s %s: %v", method, path, err)
+		}
+		return nil
+	}
+}
+
+func (c *restClient) send(method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bot "+c.token)
+	req.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s %s: %v", method, path, err)
+	}
+	return resp, nil
+}
+
+// bucket returns the rate limit bucket of a route. Until Discord tells
+// which bucket a route is in, every route has a bucket of its own.
+func (c *restClient) bucket(route, major string) *restBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := route
+	if hash, ok := c.routes[route]; ok {
+		key = hash + " " + major
+	}
+
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &restBucket{remaining: 1}
+		c.buckets[key] = b
+	}
+	return b
+}
+
+// update reads the rate limit headers of a response into the bucket.
+func (c *restClient) update(route, major string, b *restBucket, resp *http.Response) {
+	h := resp.Header
+
+	if hash := h.Get("X-RateLimit-Bucket"); hash != "" {
+		c.mu.Lock()
+		if _, ok := c.routes[route]; !ok {
+			c.routes[route] = hash
+			// routes in the same bucket share the bucket that was made first
+			if _, ok := c.buckets[hash+" "+major]; !ok {
+				c.buckets[hash+" "+major] = b
+			}
+			delete(c.buckets, route)
+		}
+		c.mu.Unlock()
+	}
+
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	resetAfter, err := strconv.ParseFloat(h.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return
+	}
+
+	b.remaining = remaining
+	b.resetAt = time.Now().Add(time.Duration(resetAfter * float64(time.Second)))
+}
+
+// rateLimited handles a 429 response, the retry waits for either the
+// global or the bucket limit to reset.
+func (c *restClient) rateLimited(b *restBucket, resp *http.Response, body []byte) {
+	var limit struct {
+		RetryAfter float64 `json:"retry_after"`
+		Global     bool    `json:"global"`
+	}
+	json.Unmarshal(body, &limit)
+
+	if limit.RetryAfter == 0 {
+		limit.RetryAfter, _ = strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+	}
+	retryAt := time.Now().Add(time.Duration(limit.RetryAfter * float64(time.Second)))
+
+	if limit.Global || resp.Header.Get("X-RateLimit-Global") == "true" {
+		log.Printf("hit the global rate limit, retrying in %.2fs\n", limit.RetryAfter)
+		c.mu.Lock()
+		c.globalUntil = retryAt
+		c.mu.Unlock()
+		return
+	}
+
+	log.Printf("rate limited on %s, retrying in %.2fs\n", resp.Request.URL.Path, limit.RetryAfter)
+	b.mu.Lock()
+	b.remaining = 0
+	b.resetAt = retryAt
+	b.mu.Unlock()
+}
+
+// wait blocks until the bucket has a request left, b.mu has to be held.
+func (b *restBucket) wait() {
+	if b.remaining > 0 {
+		b.remaining--
+		return
+	}
+
+	if wait := time.Until(b.resetAt); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (c *restClient) waitGlobal() {
+	c.mu.Lock()
+	until := c.globalUntil
+	c.mu.Unlock()
+
+	if wait := time.Until(until); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// routeKey returns the route of a request with the ids replaced, except
+// for the major parameter which gets separate limits for every id.
+func routeKey(method, path string) (string, string) {
+	// the query string does not change the bucket
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	orig := strings.Split(strings.Trim(path, "/"), "/")
+	parts := append([]string(nil), orig...)
+
+	var major string
+	for i := 1; i < len(orig); i++ {
+		prev := orig[i-1]
+
+		switch {
+		case prev == "reactions":
+			// every reaction on a message shares the same limits
+			parts = parts[:i]
+			return method + " /" + strings.Join(parts, "/"), major
+
+		case i > 1 && (orig[i-2] == "webhooks" || orig[i-2] == "interactions") && isSnowflake(prev):
+			// checked first as a token could be all digits
+			parts[i] = ":token"
+
+		case isSnowflake(orig[i]):
+			if major == "" && (prev == "channels" || prev == "guilds" || prev == "webhooks") {
+				major = prev + "/" + orig[i]
+				continue
+			}
+			parts[i] = ":id"
+		}
+	}
+
+	return method + " /" + strings.Join(parts, "/"), major
+}
+
+func isSnowflake(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// getGateway returns the url to connect to the gateway with.
+func (c *restClient) getGateway() (string, error) {
+	var u wsURL
+	err := c.request("GET", "/gateway", nil, &u)
+	if err != nil {
+		return "", fmt.Errorf("failed to get websocket url: %v", err)
+	}
+	return u.URL, nil
+}
+
+// getGatewayBot returns the gateway url together with the recommended
+// number of shards and the limits for starting new sessions.
+func (c *restClient) getGatewayBot() (gatewayBot, error) {
+	var gb gatewayBot
+	err := c.request("GET", "/gateway/bot", nil, &gb)
+	if err != nil {
+		return gatewayBot{}, fmt.Errorf("failed to get gateway bot: %v", err)
+	}
+	return gb, nil
+}
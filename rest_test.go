@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRouteKey(t *testing.T) {
+	tests := []struct {
+		method, path string
+		route, major string
+	}{
+		{"GET", "/channels/123/messages/456", "GET /channels/123/messages/:id", "channels/123"},
+		{"DELETE", "/channels/123/messages/456", "DELETE /channels/123/messages/:id", "channels/123"},
+		{"POST", "/interactions/111/aW50ZXJhY3Rpb24/callback", "POST /interactions/:id/:token/callback", ""},
+		{"POST", "/interactions/111/222/callback", "POST /interactions/:id/:token/callback", ""},
+		{"PATCH", "/webhooks/222/aW50ZXJhY3Rpb24/messages/@original", "PATCH /webhooks/222/:token/messages/@original", "webhooks/222"},
+		{"PATCH", "/webhooks/222/333/messages/444", "PATCH /webhooks/222/:token/messages/:id", "webhooks/222"},
+		{"PUT", "/channels/123/messages/456/reactions/%F0%9F%91%8D/@me", "PUT /channels/123/messages/:id/reactions", "channels/123"},
+		{"GET", "/guilds/9/members?limit=5", "GET /guilds/9/members", "guilds/9"},
+		{"GET", "/guilds/9/members/10", "GET /guilds/9/members/:id", "guilds/9"},
+		{"GET", "/gateway/bot", "GET /gateway/bot", ""},
+		{"PUT", "/applications/1/commands", "PUT /applications/:id/commands", ""},
+	}
+
+	for _, test := range tests {
+		route, major := routeKey(test.method, test.path)
+		if route != test.route || major != test.major {
+			t.Errorf("routeKey(%s %s) = %q, %q, want %q, %q", test.method, test.path, route, major, test.route, test.major)
+		}
+	}
+}
+
+// testRestClient returns a client that sends its requests to handler.
+func testRestClient(t *testing.T, handler http.HandlerFunc) *restClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c := newRestClient("token")
+	c.baseURL = server.URL
+	c.retry = restRetry{2, time.Millisecond, time.Millisecond}
+	return c
+}
+
+func TestRestBuckets(t *testing.T) {
+	c := testRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// edits and deletes of messages are in one bucket
+		w.Header().Set("X-RateLimit-Bucket", "messages")
+		w.Header().Set("X-RateLimit-Remaining", "4")
+		w.Header().Set("X-RateLimit-Reset-After", "2.5")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	paths := []string{"/channels/1/messages/10", "/channels/1/messages/11", "/channels/2/messages/12"}
+	for _, method := range []string{"PATCH", "DELETE"} {
+		for _, path := range paths {
+			err := c.request(method, path, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if hash := c.routes["PATCH /channels/1/messages/:id"]; hash != "messages" {
+		t.Errorf("route has bucket %q, want messages", hash)
+	}
+
+	edit := c.bucket(routeKey("PATCH", "/channels/1/messages/10"))
+	remove := c.bucket(routeKey("DELETE", "/channels/1/messages/10"))
+	other := c.bucket(routeKey("PATCH", "/channels/2/messages/10"))
+	if edit != remove {
+		t.Error("routes with the same bucket hash should share the bucket")
+	}
+	if edit == other {
+		t.Error("channels should have buckets of their own")
+	}
+
+	// the routes are only kept under the hash once it is known
+	for key := range c.buckets {
+		if key != "messages channels/1" && key != "messages channels/2" {
+			t.Errorf("unexpected bucket %q", key)
+		}
+	}
+
+	if edit.remaining != 4 {
+		t.Errorf("bucket has %d requests remaining, want 4", edit.remaining)
+	}
+	if reset := time.Until(edit.resetAt); reset < 2*time.Second || reset > 2500*time.Millisecond {
+		t.Errorf("bucket resets in %v, want 2.5s", reset)
+	}
+}
+
+func TestRestRateLimited(t *testing.T) {
+	var hits int32
+	c := testRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"retry_after": 0.05, "global": false}`))
+			return
+		}
+		w.Write([]byte(`{"url": "wss://gateway.discord.gg"}`))
+	})
+
+	start := time.Now()
+	url, err := c.getGateway()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "wss://gateway.discord.gg" || atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("got %q after %d requests, want the url after 2", url, hits)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("the retry did not wait for retry_after")
+	}
+}
+
+func TestRestRetries(t *testing.T) {
+	// requests that get a response are retried whatever the method
+	var hits int32
+	c := testRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	err := c.request("POST", "/channels/1/messages", nil, nil)
+	if err == nil || atomic.LoadInt32(&hits) != 3 {
+		t.Errorf("POST with server errors got %v after %d requests, want an error after 3", err, hits)
+	}
+
+	// requests without a response are only retried when idempotent
+	atomic.StoreInt32(&hits, 0)
+	c = testRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+
+	err = c.request("POST", "/channels/1/messages", nil, nil)
+	if err == nil || atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("POST without a response got %v after %d requests, want an error after 1", err, hits)
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	err = c.request("GET", "/channels/1/messages", nil, nil)
+	if err == nil || atomic.LoadInt32(&hits) < 3 {
+		t.Errorf("GET without a response got %v after %d requests, want an error after 3", err, hits)
+	}
+}
+
+func TestRestRetryBackoff(t *testing.T) {
+	r := restRetry{3, time.Second, 10 * time.Second}
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 40: 10 * time.Second} {
+		if got := r.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// defaultPrefix starts commands in guilds that did not set their own prefix
+const defaultPrefix = "!"
+
+// maxPrefixLength keeps prefixes short enough to type
+const maxPrefixLength = 5
+
+// Argument types of commands
+const (
+	argInt      = iota + 1
+	argDuration // m:ss, h:mm:ss or seconds
+	argUser     // a user mention or id
+	argChannel  // a channel mention or id
+	argText     // the rest of the line
+)
+
+// commandArg describes an argument, it is parsed from the text after the
+// command name or from the slash command option with the same name.
+type commandArg struct {
+	name        string
+	description string
+	kind        int
+	optional    bool
+	// min is the smallest value of an argInt, 0 for no minimum
+	min int
+	// autocomplete suggests values while typing the slash command option
+	autocomplete autocompleteHandler
+}
+
+// botCommand is a command that can be used with a prefix and as a slash command
+type botCommand struct {
+	name        string
+	aliases     []string
+	description string
+	args        []commandArg
+	// slow commands defer the reply to slash commands, as the first
+	// response has to be sent within 3 seconds
+	slow bool
+	run  func(r commandRequest, args commandArgs) *messageSend
+}
+
+// commandArgs holds the parsed arguments by name, optional arguments
+// that were not given are missing
+type commandArgs map[string]interface{}
+
+func (a commandArgs) int(name string) (int, bool) {
+	n, ok := a[name].(int)
+	return n, ok
+}
+
+func (a commandArgs) duration(name string) (time.Duration, bool) {
+	d, ok := a[name].(time.Duration)
+	return d, ok
+}
+
+// text returns a text argument or the id of a user or channel argument.
+func (a commandArgs) text(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// commandRouter runs the commands in messages starting with the prefix of
+// the guild or a mention of the bot, and the slash commands with the same
+// names. The prefixes are not saved and reset when the bot restarts.
+type commandRouter struct {
+	rest     *restClient
+	state    *state
+	registry *commandRegistry
+
+	mu       sync.Mutex
+	commands []*botCommand
+	names    map[string]*botCommand // names and aliases
+	prefixes map[string]string
+}
+
+func newCommandRouter(rest *restClient, s *state, registry *commandRegistry) *commandRouter {
+	r := &commandRouter{
+		rest:     rest,
+		state:    s,
+		registry: registry,
+		names:    make(map[string]*botCommand),
+		prefixes: make(map[string]string)}
+
+	r.add(&botCommand{
+		name:        "help",
+		aliases:     []string{"h"},
+		description: "List the commands or show how to use one",
+		args: []commandArg{{
+			name:        "command",
+			description: "Command to show",
+			kind:        argText,
+			optional:    true}},
+		run: func(req commandRequest, args commandArgs) *messageSend {
+			return r.help(r.prefix(req.guildID), args.text("command"))
+		}})
+
+	r.add(&botCommand{
+		name:        "prefix",
+		description: "Change the prefix of the commands in this server",
+		args: []commandArg{{
+			name:        "prefix",
+			description: "New prefix",
+			kind:        argText}},
+		run: r.changePrefix})
+
+	return r
+}
+
+// add makes a command available with the prefix and as a slash command,
+// names and aliases have to be lowercase.
+func (r *commandRouter) add(c *botCommand) {
+	r.mu.Lock()
+	r.commands = append(r.commands, c)
+	r.names[c.name] = c
+	for _, alias := range c.aliases {
+		r.names[alias] = c
+	}
+	r.mu.Unlock()
+
+	r.registry.register(c.applicationCommand(), r.slashHandler(c))
+
+	for _, a := range c.args {
+		if a.autocomplete != nil {
+			r.registry.handleAutocomplete(c.name, c.autocomplete)
+			break
+		}
+	}
+}
+
+func (r *commandRouter) command(name string) (*botCommand, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.names[strings.ToLower(name)]
+	return c, ok
+}
+
+// prefix returns the prefix of the commands in a guild.
+func (r *commandRouter) prefix(guildID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.prefixes[guildID]; ok {
+		return p
+	}
+	return defaultPrefix
+}
+
+// handleMessage runs the command in a message, if it is one.
+func (r *commandRouter) handleMessage(m *message) {
+	if m.Author.Bot {
+		return
+	}
+
+	line, mentioned := r.trimPrefix(m.GuildID, m.Content)
+	if !mentioned && line == m.Content {
+		return
+	}
+
+	name, line := nextWord(line)
+	req := commandRequest{m.GuildID, m.ChannelID, m.Author.ID, m.Member}
+	prefix := r.prefix(m.GuildID)
+
+	// a mention without a command is answered with the help
+	if name == "" && mentioned {
+		r.reply(m, r.help(prefix, ""))
+		return
+	}
+
+	c, ok := r.command(name)
+	if !ok {
+		return
+	}
+
+	args, err := c.parse(line)
+	if err != nil {
+		r.reply(m, text(fmt.Sprintf("Usage: `%s` (%v)", c.usage(prefix), err)))
+		return
+	}
+
+	r.reply(m, c.run(req, args))
+}
+
+// trimPrefix removes the prefix of the guild or a mention of the bot from
+// the start of content, mentioned tells if the bot was mentioned.
+func (r *commandRouter) trimPrefix(guildID, content string) (line string, mentioned bool) {
+	botID := r.state.botUser().ID
+	if botID != "" {
+		for _, mention := range []string{"<@" + botID + ">", "<@!" + botID + ">"} {
+			if strings.HasPrefix(content, mention) {
+				return strings.TrimSpace(content[len(mention):]), true
+			}
+		}
+	}
+
+	prefix := r.prefix(guildID)
+	if strings.HasPrefix(content, prefix) {
+		return content[len(prefix):], false
+	}
+	return content, false
+}
+
+// slashHandler runs a command used as a slash command.
+func (r *commandRouter) slashHandler(c *botCommand) commandHandler {
+	return func(ctx *interactionContext) {
+		i := ctx.interaction
+
+		if c.slow {
+			err := ctx.deferReply()
+			if err != nil {
+				log.Printf("error deferring /%s: %v\n", c.name, err)
+				return
+			}
+		}
+
+		args, err := c.parseOptions(&i.Data)
+		if err != nil {
+			r.replySlash(ctx, text(fmt.Sprintf("Usage: `%s` (%v)", c.usage("/"), err)))
+			return
+		}
+
+		r.replySlash(ctx, c.run(i.request(), args))
+	}
+}
+
+// replySlash answers a slash command without pinging anyone.
+func (r *commandRouter) replySlash(ctx *interactionContext, send *messageSend) {
+	send.AllowedMentions = &allowedMentions{Parse: []string{}}
+
+	err := ctx.reply(send)
+	if err != nil {
+		log.Printf("error replying to /%s: %v\n", ctx.interaction.Data.Name, err)
+	}
+}
+
+// reply answers a command message without pinging anyone.
+func (r *commandRouter) reply(m *message, send *messageSend) {
+	send.MessageReference = &messageReference{MessageID: m.ID}
+	send.AllowedMentions = &allowedMentions{Parse: []string{}}
+
+	_, err := r.rest.createMessage(m.ChannelID, send)
+	if err != nil {
+		log.Printf("error replying to %q: %v\n", m.Content, err)
+	}
+}
+
+// help lists the commands, or shows the arguments and aliases of one command.
+func (r *commandRouter) help(prefix, name string) *messageSend {
+	if name != "" {
+		c, ok := r.command(strings.TrimPrefix(name, prefix))
+		if !ok {
+			return text(fmt.Sprintf("There is no command called %q", name))
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "`%s`\n%s\n", c.usage(prefix), c.description)
+		for _, a := range c.args {
+			fmt.Fprintf(&sb, "`%s` %s\n", a.name, a.description)
+		}
+		if len(c.aliases) > 0 {
+			fmt.Fprintf(&sb, "Aliases: %s%s", prefix, strings.Join(c.aliases, ", "+prefix))
+		}
+		return text(strings.TrimSpace(sb.String()))
+	}
+
+	r.mu.Lock()
+	commands := append([]*botCommand(nil), r.commands...)
+	r.mu.Unlock()
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].name < commands[j].name
+	})
+
+	var sb strings.Builder
+	for _, c := range commands {
+		fmt.Fprintf(&sb, "`%s` %s\n", c.usage(prefix), c.description)
+	}
+	fmt.Fprintf(&sb, "Use `%shelp <command>` for more, all commands are also slash commands.", prefix)
+	return text(sb.String())
+}
+
+// permissions returns the permissions of the author of a command in its
+// channel. Interactions come with them, for messages they are computed
+// from the roles of the author as members are not cached.
+func (r *commandRouter) permissions(req commandRequest) (int64, error) {
+	if req.member == nil {
+		return 0, fmt.Errorf("user %s is not a member of guild %s", req.userID, req.guildID)
+	}
+	if req.member.Permissions != "" {
+		return parsePermissions(req.member.Permissions), nil
+	}
+	return r.state.memberPermissions(req.channelID, req.userID, req.member)
+}
+
+// changePrefix sets the prefix of a guild, which needs the
+// manage server permission.
+func (r *commandRouter) changePrefix(req commandRequest, args commandArgs) *messageSend {
+	if req.guildID == "" {
+		return text("The prefix can only be changed in servers")
+	}
+
+	perms, err := r.permissions(req)
+	if err != nil {
+		log.Printf("error checking permissions for the prefix: %v\n", err)
+		return text("Could not check your permissions")
+	}
+	if perms&permissionManageGuild == 0 {
+		return text("You need the Manage Server permission to change the prefix")
+	}
+
+	prefix := args.text("prefix")
+	if strings.IndexFunc(prefix, unicode.IsSpace) != -1 || len([]rune(prefix)) > maxPrefixLength {
+		return text(fmt.Sprintf("The prefix must be at most %d characters without spaces", maxPrefixLength))
+	}
+
+	r.mu.Lock()
+	r.prefixes[req.guildID] = prefix
+	r.mu.Unlock()
+
+	return text(fmt.Sprintf("Commands now start with `%s`, like `%shelp`", prefix, prefix))
+}
+
+// usage shows how to use a command, optional arguments are in brackets.
+func (c *botCommand) usage(prefix string) string {
+	s := prefix + c.name
+	for _, a := range c.args {
+		if a.optional {
+			s += " [" + a.name + "]"
+		} else {
+			s += " <" + a.name + ">"
+		}
+	}
+	return s
+}
+
+// parse parses the arguments in the text after the command name.
+func (c *botCommand) parse(line string) (commandArgs, error) {
+	args := make(commandArgs)
+
+	for _, a := range c.args {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if a.optional {
+				continue
+			}
+			return nil, fmt.Errorf("%s is missing", a.name)
+		}
+
+		var word string
+		if a.kind == argText {
+			word, line = line, ""
+		} else {
+			word, line = nextWord(line)
+		}
+
+		v, err := a.parse(word)
+		if err != nil {
+			return nil, err
+		}
+		args[a.name] = v
+	}
+
+	if strings.TrimSpace(line) != "" {
+		return nil, errors.New("too many arguments")
+	}
+	return args, nil
+}
+
+// parseOptions parses the options of a slash command, which are
+// checked the same way as the arguments in a message.
+func (c *botCommand) parseOptions(d *interactionData) (commandArgs, error) {
+	args := make(commandArgs)
+
+	for _, a := range c.args {
+		o, ok := d.option(a.name)
+		if !ok {
+			if a.optional {
+				continue
+			}
+			return nil, fmt.Errorf("%s is missing", a.name)
+		}
+
+		// strings are unquoted, numbers are kept as they are
+		value := string(o.Value)
+		var s string
+		if json.Unmarshal(o.Value, &s) == nil {
+			value = s
+		}
+
+		v, err := a.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		args[a.name] = v
+	}
+
+	return args, nil
+}
+
+// autocomplete routes an autocomplete interaction to the argument
+// that is being typed.
+func (c *botCommand) autocomplete(i *interaction, focused *interactionOption) []commandChoice {
+	for _, a := range c.args {
+		if a.name == focused.Name && a.autocomplete != nil {
+			return a.autocomplete(i, focused)
+		}
+	}
+	return nil
+}
+
+// applicationCommand describes the command as a slash command.
+func (c *botCommand) applicationCommand() applicationCommand {
+	cmd := applicationCommand{Name: c.name, Description: c.description}
+
+	for _, a := range c.args {
+		o := commandOption{
+			Name:         a.name,
+			Description:  a.description,
+			Required:     !a.optional,
+			Autocomplete: a.autocomplete != nil}
+
+		switch a.kind {
+		case argInt:
+			o.Type = optionInteger
+			if a.min != 0 {
+				min := float64(a.min)
+				o.MinValue = &min
+			}
+		case argUser:
+			o.Type = optionUser
+		case argChannel:
+			o.Type = optionChannel
+		default:
+			o.Type = optionString
+		}
+
+		cmd.Options = append(cmd.Options, o)
+	}
+
+	return cmd
+}
+
+// parse parses the value of the argument from a word, or the rest of
+// the line for argText.
+func (a *commandArg) parse(s string) (interface{}, error) {
+	switch a.kind {
+	case argInt:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", a.name)
+		}
+		if a.min != 0 && n < a.min {
+			return nil, fmt.Errorf("%s must be at least %d", a.name, a.min)
+		}
+		return n, nil
+
+	case argDuration:
+		d, err := parseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a time like 1:30", a.name)
+		}
+		return d, nil
+
+	case argUser:
+		id, ok := parseMention(s, "<@!", "<@")
+		if !ok {
+			return nil, fmt.Errorf("%s must be a user mention", a.name)
+		}
+		return id, nil
+
+	case argChannel:
+		id, ok := parseMention(s, "<#")
+		if !ok {
+			return nil, fmt.Errorf("%s must be a channel mention", a.name)
+		}
+		return id, nil
+	}
+
+	return s, nil
+}
+
+// parseDuration parses seconds, m:ss or h:mm:ss.
+func parseDuration(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	var d time.Duration
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		// minutes and seconds after the first part are below 60
+		if i > 0 && (n >= 60 || len(p) != 2) {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d = d*60 + time.Duration(n)*time.Second
+	}
+	return d, nil
+}
+
+// parseMention returns the id in a mention starting with one of the
+// prefixes, a plain id is accepted too.
+func parseMention(s string, prefixes ...string) (string, bool) {
+	if isSnowflake(s) {
+		return s, true
+	}
+
+	if !strings.HasSuffix(s, ">") {
+		return "", false
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			if id := s[len(p) : len(s)-1]; isSnowflake(id) {
+				return id, true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// nextWord splits the first word from the rest of s.
+func nextWord(s string) (word, rest string) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
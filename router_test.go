@@ -0,0 +1,187 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRouter returns a router in guild 1 with the bot as user 42. Members
+// of role 3 can manage the server, channel 10 denies it to role 4.
+func testRouter() *commandRouter {
+	s := newState()
+	s.ready(&ready{User: user{ID: "42", Bot: true}})
+	s.guildUpdate(&guild{
+		ID:      "1",
+		OwnerID: "100",
+		Roles: []role{
+			{ID: "1", Permissions: "0"},
+			{ID: "3", Permissions: "32"},
+			{ID: "4", Permissions: "32"}},
+		Channels: []channel{{ID: "10", PermissionOverwrites: []permissionOverwrite{{ID: "4", Deny: "32"}}}}}, true)
+
+	return newCommandRouter(nil, s, newCommandRegistry(nil))
+}
+
+func TestTrimPrefix(t *testing.T) {
+	r := testRouter()
+	r.prefixes["2"] = "?"
+
+	tests := []struct {
+		guildID, content string
+		line             string
+		mentioned        bool
+	}{
+		{"1", "!play song", "play song", false},
+		{"1", "!", "", false},
+		{"1", "?play song", "?play song", false},
+		{"2", "?play song", "play song", false},
+		{"2", "!play song", "!play song", false},
+		{"1", "<@42> play song", "play song", true},
+		{"1", "<@!42>   play song", "play song", true},
+		{"1", "<@42>", "", true},
+		{"1", "<@43> play song", "<@43> play song", false},
+		{"1", "play song", "play song", false},
+	}
+
+	for _, test := range tests {
+		line, mentioned := r.trimPrefix(test.guildID, test.content)
+		if line != test.line || mentioned != test.mentioned {
+			t.Errorf("trimPrefix(%s, %q) = %q, %v, want %q, %v", test.guildID, test.content, line, mentioned, test.line, test.mentioned)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	c := &botCommand{name: "test", args: []commandArg{
+		{name: "count", kind: argInt, min: 1},
+		{name: "at", kind: argDuration},
+		{name: "user", kind: argUser},
+		{name: "channel", kind: argChannel, optional: true},
+		{name: "text", kind: argText, optional: true}}}
+
+	tests := []struct {
+		line string
+		want commandArgs
+	}{
+		{"2 1:30 <@5>", commandArgs{"count": 2, "at": 90 * time.Second, "user": "5"}},
+		{"  2   90  <@!5>  <#6>", commandArgs{"count": 2, "at": 90 * time.Second, "user": "5", "channel": "6"}},
+		{"2 0:05 5 6 the rest  of it ", commandArgs{"count": 2, "at": 5 * time.Second, "user": "5", "channel": "6", "text": "the rest  of it"}},
+	}
+
+	for _, test := range tests {
+		args, err := c.parse(test.line)
+		if err != nil {
+			t.Errorf("parse(%q): %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.want) {
+			t.Errorf("parse(%q) = %v, want %v", test.line, args, test.want)
+		}
+	}
+
+	bad := map[string]string{
+		"":               "count is missing",
+		"2 1:30":         "user is missing",
+		"0 1:30 <@5>":    "count must be at least 1",
+		"two 1:30 <@5>":  "count must be a number",
+		"2 1:3 <@5>":     "at must be a time",
+		"2 1:30 <#5>":    "user must be a user mention",
+		"2 1:30 <@5> @6": "channel must be a channel mention",
+	}
+	for line, want := range bad {
+		_, err := c.parse(line)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("parse(%q) = %v, want %q", line, err, want)
+		}
+	}
+
+	noArgs := &botCommand{name: "skip"}
+	if _, err := noArgs.parse("now"); err == nil || err.Error() != "too many arguments" {
+		t.Errorf("parse with an extra argument = %v, want too many arguments", err)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"0":        0,
+		"45":       45 * time.Second,
+		"90":       90 * time.Second,
+		"1:30":     90 * time.Second,
+		"0:05":     5 * time.Second,
+		"12:00":    12 * time.Minute,
+		"1:02:03":  time.Hour + 2*time.Minute + 3*time.Second,
+		"10:59:59": 10*time.Hour + 59*time.Minute + 59*time.Second,
+	}
+	for s, want := range tests {
+		d, err := parseDuration(s)
+		if err != nil || d != want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v", s, d, err, want)
+		}
+	}
+
+	for _, s := range []string{"", ":", "1:", ":30", "1:5", "1:60", "1:030", "1:2:3:4", "-5", "1:-5", "1m", "one"} {
+		if _, err := parseDuration(s); err == nil {
+			t.Errorf("parseDuration(%q) should fail", s)
+		}
+	}
+}
+
+func TestParseMention(t *testing.T) {
+	users := []string{"<@!", "<@"}
+	tests := []struct {
+		s        string
+		prefixes []string
+		id       string
+		ok       bool
+	}{
+		{"<@123>", users, "123", true},
+		{"<@!123>", users, "123", true},
+		{"123", users, "123", true},
+		{"<#123>", []string{"<#"}, "123", true},
+		{"<#123>", users, "", false},
+		{"<@&123>", users, "", false},
+		{"<@123", users, "", false},
+		{"<@>", users, "", false},
+		{"<@12a>", users, "", false},
+		{"@someone", users, "", false},
+		{"", users, "", false},
+	}
+
+	for _, test := range tests {
+		id, ok := parseMention(test.s, test.prefixes...)
+		if id != test.id || ok != test.ok {
+			t.Errorf("parseMention(%q) = %q, %v, want %q, %v", test.s, id, ok, test.id, test.ok)
+		}
+	}
+}
+
+func TestChangePrefixPermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		member  *member
+		allowed bool
+	}{
+		// messages only have the roles of the author, who is not cached
+		{"role", "7", &member{Roles: []string{"3"}}, true},
+		{"no role", "7", &member{Roles: []string{"2"}}, false},
+		{"denied in channel", "7", &member{Roles: []string{"4"}}, false},
+		{"owner", "100", &member{}, true},
+		// interactions have the permissions in the channel
+		{"interaction", "7", &member{Permissions: "32"}, true},
+		{"interaction without permission", "7", &member{Roles: []string{"3"}, Permissions: "2048"}, false},
+		{"no member", "7", nil, false},
+	}
+
+	for _, test := range tests {
+		r := testRouter()
+		req := commandRequest{"1", "10", test.userID, test.member}
+		r.changePrefix(req, commandArgs{"prefix": "?"})
+
+		if got := r.prefix("1") == "?"; got != test.allowed {
+			t.Errorf("%s: prefix changed %v, want %v", test.name, got, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// shardManager runs one gateway connection for every shard, each
+// shard receives the events for its part of the guilds.
+type shardManager struct {
+	shards []*gateway
+	events *dispatcher
+}
+
+// newShardManager asks Discord for the recommended number of shards and
+// creates a gateway for each of them. The options are used for every shard
+// and all shards deliver their events to the same dispatcher.
+func newShardManager(rest *restClient, options ...gatewayOption) (*shardManager, error) {
+	gb, err := rest.getGatewayBot()
+	if err != nil {
+		return nil, fmt.Errorf("error getting gateway bot: %v", err)
+	}
+
+	count := gb.Shards
+	if count < 1 {
+		count = 1
+	}
+
+	limiter := newIdentifyLimiter(rest, gb.SessionStartLimit.MaxConcurrency)
+	limiter.update(gb.SessionStartLimit)
+	m := shardManager{events: newDispatcher(false)}
+
+	for i := 0; i < count; i++ {
+		shardOptions := append(options[:len(options):len(options)],
+			withURL(gb.URL),
+			withShard(i, count),
+			withIdentifyLimiter(limiter),
+			withRestClient(rest),
+			withDispatcher(m.events))
+
+		g, err := newGateway(rest.token, shardOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating shard %d: %v", i, err)
+		}
+		m.shards = append(m.shards, g)
+	}
+
+	log.Printf("created %d shards\n", count)
+	return &m, nil
+}
+
+// open starts listening for events on every shard, the identify
+// limiter makes sure the shards identify in the right order.
+func (m *shardManager) open() {
+	for _, g := range m.shards {
+		go g.open()
+	}
+}
+
+// shardFor returns the gateway receiving the events of a guild.
+func (m *shardManager) shardFor(guildID string) *gateway {
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil {
+		log.Printf("invalid guild id %q: %v\n", guildID, err)
+		return m.shards[0]
+	}
+
+	return m.shards[(id>>22)%uint64(len(m.shards))]
+}
+
+// write sends a payload on the shard of a guild.
+func (m *shardManager) write(guildID string, v interface{}) error {
+	return m.shardFor(guildID).write(v)
+}
+
+// requestVoice asks the shard of the guild to join a voice channel.
+func (m *shardManager) requestVoice(guildID, channelID string) error {
+	return m.shardFor(guildID).requestVoice(guildID, channelID)
+}
+
+// identifyLimiter spreads out identifies, shards with the same rate limit
+// key (shard_id % max_concurrency) may only identify once every 5 seconds.
+// It also keeps track of the session_start_limit so reconnect loops wait
+// for the limit to reset instead of using up every identify.
+type identifyLimiter struct {
+	mu             sync.Mutex
+	rest           *restClient
+	maxConcurrency int
+	next           map[int]time.Time
+	remaining      int
+	resetAt        time.Time
+}
+
+// newIdentifyLimiter creates a limiter without any known session starts,
+// the limit is fetched from Discord on the first identify unless update
+// is called before that.
+func newIdentifyLimiter(rest *restClient, maxConcurrency int) *identifyLimiter {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+
+	return &identifyLimiter{
+		rest:           rest,
+		maxConcurrency: maxConcurrency,
+		next:           make(map[int]time.Time)}
+}
+
+// update sets the session start limit as returned by /gateway/bot.
+func (l *identifyLimiter) update(s sessionStartLimit) {
+	l.remaining = s.Remaining
+	l.resetAt = time.Now().Add(time.Millisecond * time.Duration(s.ResetAfter))
+	if s.MaxConcurrency > 0 {
+		l.maxConcurrency = s.MaxConcurrency
+	}
+}
+
+// wait blocks until the shard is allowed to identify.
+func (l *identifyLimiter) wait(shardID int) {
+	l.mu.Lock()
+	l.reserveSession()
+
+	key := shardID % l.maxConcurrency
+	now := time.Now()
+	at := l.next[key]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[key] = at.Add(time.Second * 5)
+	l.mu.Unlock()
+
+	time.Sleep(at.Sub(now))
+}
+
+// reserveSession takes one of the remaining session starts, when none
+// are left it waits for the limit to reset. l.mu has to be held.
+func (l *identifyLimiter) reserveSession() {
+	for l.remaining <= 0 {
+		if wait := time.Until(l.resetAt); wait > 0 {
+			log.Printf("session start limit reached, waiting %v\n", wait)
+			time.Sleep(wait)
+		}
+
+		gb, err := l.rest.getGatewayBot()
+		if err != nil {
+			log.Printf("error refreshing session start limit: %v\n", err)
+			l.resetAt = time.Now().Add(time.Second * 5)
+			continue
+		}
+		l.update(gb.SessionStartLimit)
+
+		// avoid asking Discord again right away
+		if l.remaining <= 0 && !l.resetAt.After(time.Now()) {
+			l.resetAt = time.Now().Add(time.Second * 5)
+		}
+	}
+
+	l.remaining--
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"sync"
+)
+
+// state caches guilds, channels, roles, members and voice states
+// using the events received from the gateway.
+type state struct {
+	mu       sync.RWMutex
+	user     user
+	guilds   map[string]*guildState
+	channels map[string]*channel
+}
+
+type guildState struct {
+	guild
+	roles       map[string]role
+	channels    map[string]*channel
+	members     map[string]member
+	voiceStates map[string]voiceStateUpdateResponse
+}
+
+func newState() *state {
+	return &state{
+		guilds:   make(map[string]*guildState),
+		channels: make(map[string]*channel)}
+}
+
+// register adds the handlers keeping the state up to date. They are sync
+// handlers so the events are applied in the order Discord sent them.
+func (s *state) register(d *dispatcher) {
+	d.addSyncHandler(readyEvent, func(data eventData) {
+		var r ready
+		if decodeEvent(readyEvent, data, &r) {
+			s.ready(&r)
+		}
+	})
+
+	for _, event := range []string{guildCreateEvent, guildUpdateEvent} {
+		event := event
+		d.addSyncHandler(event, func(data eventData) {
+			var g guild
+			if decodeEvent(event, data, &g) {
+				s.guildUpdate(&g, event == guildCreateEvent)
+			}
+		})
+	}
+
+	d.addSyncHandler(guildDeleteEvent, func(data eventData) {
+		var g unavailableGuilde
+		if decodeEvent(guildDeleteEvent, data, &g) {
+			s.guildDelete(&g)
+		}
+	})
+
+	for _, event := range []string{channelCreateEvent, channelUpdateEvent, channelDeleteEvent} {
+		event := event
+		d.addSyncHandler(event, func(data eventData) {
+			var c channel
+			if decodeEvent(event, data, &c) {
+				s.channelUpdate(&c, event == channelDeleteEvent)
+			}
+		})
+	}
+
+	for _, event := range []string{guildRoleCreateEvent, guildRoleUpdateEvent} {
+		event := event
+		d.addSyncHandler(event, func(data eventData) {
+			var r guildRoleUpdate
+			if decodeEvent(event, data, &r) {
+				s.roleUpdate(&r)
+			}
+		})
+	}
+
+	d.addSyncHandler(guildRoleDeleteEvent, func(data eventData) {
+		var r guildRoleDelete
+		if decodeEvent(guildRoleDeleteEvent, data, &r) {
+			s.roleDelete(&r)
+		}
+	})
+
+	for _, event := range []string{guildMemberAddEvent, guildMemberUpdateEvent} {
+		event := event
+		d.addSyncHandler(event, func(data eventData) {
+			var m guildMemberUpdate
+			if decodeEvent(event, data, &m) {
+				s.memberUpdate(m.GuildID, m.member)
+			}
+		})
+	}
+
+	d.addSyncHandler(guildMemberRemoveEvent, func(data eventData) {
+		var m guildMemberRemove
+		if decodeEvent(guildMemberRemoveEvent, data, &m) {
+			s.memberRemove(&m)
+		}
+	})
+
+	d.addSyncHandler(voiceStateUpdateEvent, func(data eventData) {
+		var vs voiceStateUpdateResponse
+		if decodeEvent(voiceStateUpdateEvent, data, &vs) {
+			s.voiceStateUpdate(&vs)
+		}
+	})
+}
+
+func (s *state) ready(r *ready) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user = r.User
+	for _, g := range r.UnavailableGuildes {
+		if _, ok := s.guilds[g.GuildID]; !ok {
+			s.guilds[g.GuildID] = newGuildState(guild{ID: g.GuildID, Unavailable: true})
+		}
+	}
+}
+
+func newGuildState(g guild) *guildState {
+	return &guildState{
+		guild:       g,
+		roles:       make(map[string]role),
+		channels:    make(map[string]*channel),
+		members:     make(map[string]member),
+		voiceStates: make(map[string]voiceStateUpdateResponse)}
+}
+
+// guildUpdate stores a guild, GUILD_CREATE replaces everything
+// while GUILD_UPDATE keeps the channels, members and voice states.
+func (s *state) guildUpdate(g *guild, create bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gs, ok := s.guilds[g.ID]
+	if !ok || create {
+		if ok {
+			s.removeChannels(gs)
+		}
+		gs = newGuildState(guild{})
+		s.guilds[g.ID] = gs
+	}
+
+	gs.ID = g.ID
+	gs.Name = g.Name
+	gs.OwnerID = g.OwnerID
+	gs.Unavailable = g.Unavailable
+
+	gs.roles = make(map[string]role)
+	for _, r := range g.Roles {
+		gs.roles[r.ID] = r
+	}
+
+	// the guild id is left out of the lists in GUILD_CREATE
+	for i := range g.Channels {
+		c := g.Channels[i]
+		c.GuildID = g.ID
+		gs.channels[c.ID] = &c
+		s.channels[c.ID] = &c
+	}
+
+	for _, m := range g.Members {
+		gs.members[m.User.ID] = m
+	}
+
+	for _, vs := range g.VoiceStates {
+		vs.GuildID = g.ID
+		gs.voiceStates[vs.UserID] = vs
+	}
+}
+
+func (s *state) guildDelete(g *unavailableGuilde) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gs, ok := s.guilds[g.GuildID]
+	if !ok {
+		return
+	}
+
+	// an unavailable guild is an outage, the bot is still a member
+	if g.Unavailable {
+		gs.Unavailable = true
+		return
+	}
+
+	s.removeChannels(gs)
+	delete(s.guilds, g.GuildID)
+}
+
+func (s *state) removeChannels(gs *guildState) {
+	for id := range gs.channels {
+		delete(s.channels, id)
+	}
+}
+
+func (s *state) channelUpdate(c *channel, deleted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gs := s.guilds[c.GuildID]
+	if deleted {
+		delete(s.channels, c.ID)
+		if gs != nil {
+			delete(gs.channels, c.ID)
+		}
+		return
+	}
+
+	s.channels[c.ID] = c
+	if gs != nil {
+		gs.channels[c.ID] = c
+	}
+}
+
+func (s *state) roleUpdate(r *guildRoleUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gs, ok := s.guilds[r.GuildID]; ok {
+		gs.roles[r.Role.ID] = r.Role
+	}
+}
+
+func (s *state) roleDelete(r *guildRoleDelete) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gs, ok := s.guilds[r.GuildID]; ok {
+		delete(gs.roles, r.RoleID)
+	}
+}
+
+func (s *state) memberUpdate(guildID string, m member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gs, ok := s.guilds[guildID]; ok {
+		gs.members[m.User.ID] = m
+	}
+}
+
+func (s *state) memberRemove(m *guildMemberRemove) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gs, ok := s.guilds[m.GuildID]; ok {
+		delete(gs.members, m.User.ID)
+		delete(gs.voiceStates, m.User.ID)
+	}
+}
+
+func (s *state) voiceStateUpdate(vs *voiceStateUpdateResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gs, ok := s.guilds[vs.GuildID]
+	if !ok {
+		return
+	}
+
+	if vs.Member.User.ID != "" {
+		gs.members[vs.Member.User.ID] = vs.Member
+	}
+
+	// leaving voice is sent as an update without a channel
+	if vs.ChannelID == "" {
+		delete(gs.voiceStates, vs.UserID)
+		return
+	}
+	gs.voiceStates[vs.UserID] = *vs
+}
+
+// botUser returns the user of the bot.
+func (s *state) botUser() user {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.user
+}
+
+// guild returns the guild with its roles, channels, members and voice states.
+func (s *state) guild(guildID string) (guild, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gs, ok := s.guilds[guildID]
+	if !ok {
+		return guild{}, false
+	}
+
+	g := gs.guild
+	g.Roles = make([]role, 0, len(gs.roles))
+	for _, r := range gs.roles {
+		g.Roles = append(g.Roles, r)
+	}
+	g.Channels = make([]channel, 0, len(gs.channels))
+	for _, c := range gs.channels {
+		g.Channels = append(g.Channels, *c)
+	}
+	g.Members = make([]member, 0, len(gs.members))
+	for _, m := range gs.members {
+		g.Members = append(g.Members, m)
+	}
+	g.VoiceStates = make([]voiceStateUpdateResponse, 0, len(gs.voiceStates))
+	for _, vs := range gs.voiceStates {
+		g.VoiceStates = append(g.VoiceStates, vs)
+	}
+
+	return g, true
+}
+
+func (s *state) channel(channelID string) (channel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.channels[channelID]
+	if !ok {
+		return channel{}, false
+	}
+	return *c, true
+}
+
+func (s *state) role(guildID, roleID string) (role, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gs, ok := s.guilds[guildID]
+	if !ok {
+		return role{}, false
+	}
+	r, ok := gs.roles[roleID]
+	return r, ok
+}
+
+func (s *state) member(guildID, userID string) (member, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gs, ok := s.guilds[guildID]
+	if !ok {
+		return member{}, false
+	}
+	m, ok := gs.members[userID]
+	return m, ok
+}
+
+func (s *state) voiceState(guildID, userID string) (voiceStateUpdateResponse, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gs, ok := s.guilds[guildID]
+	if !ok {
+		return voiceStateUpdateResponse{}, false
+	}
+	vs, ok := gs.voiceStates[userID]
+	return vs, ok
+}
+
+// voiceChannelOf returns the voice channel a user is connected to.
+func (s *state) voiceChannelOf(guildID, userID string) (string, bool) {
+	vs, ok := s.voiceState(guildID, userID)
+	if !ok {
+		return "", false
+	}
+	return vs.ChannelID, true
+}
+
+// voiceChannelUsers returns the ids of the users in a voice channel.
+func (s *state) voiceChannelUsers(guildID, channelID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gs, ok := s.guilds[guildID]
+	if !ok {
+		return nil
+	}
+
+	var users []string
+	for userID, vs := range gs.voiceStates {
+		if vs.ChannelID == channelID {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// maxHistory is the number of tracks remembered for every guild
+	maxHistory = 200
+	// maxIndexedTracks is the number of tracks in the track index, the
+	// least recently used are removed first
+	maxIndexedTracks = 5000
+)
+
+// suggestionProvider suggests tracks for the words a user typed so far,
+// the query can be empty or end with a word that is not complete.
+type suggestionProvider interface {
+	suggest(guildID, query string, limit int) []*track
+}
+
+// suggestionChain asks the providers in order and leaves out the
+// tracks an earlier provider already suggested
+type suggestionChain []suggestionProvider
+
+func (c suggestionChain) suggest(guildID, query string, limit int) []*track {
+	var tracks []*track
+	seen := make(map[string]bool)
+
+	for _, p := range c {
+		for _, t := range p.suggest(guildID, query, limit) {
+			if len(tracks) == limit {
+				return tracks
+			}
+			if seen[t.url] {
+				continue
+			}
+			seen[t.url] = true
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// playHistory remembers the tracks played in every guild, the most
+// recent first
+type playHistory struct {
+	mu     sync.Mutex
+	guilds map[string][]*track
+}
+
+func newPlayHistory() *playHistory {
+	return &playHistory{guilds: make(map[string][]*track)}
+}
+
+// record moves t to the front of the history of a guild.
+func (h *playHistory) record(guildID string, t *track) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tracks := []*track{t}
+	for _, old := range h.guilds[guildID] {
+		if old.url != t.url && len(tracks) < maxHistory {
+			tracks = append(tracks, old)
+		}
+	}
+	h.guilds[guildID] = tracks
+}
+
+// suggest returns the recent tracks matching the query, without a query
+// the most recent tracks are returned.
+func (h *playHistory) suggest(guildID, query string, limit int) []*track {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	words := searchWords(query)
+	var tracks []*track
+	for _, t := range h.guilds[guildID] {
+		if len(tracks) == limit {
+			break
+		}
+		if matchesWords(t.title, words) {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// trackIndex keeps the tracks found with yt-dlp so they can be searched
+// and played again without looking them up
+type trackIndex struct {
+	mu     sync.Mutex
+	tracks map[string]*list.Element
+	// order has the tracks with the most recently used first
+	order *list.List
+	max   int
+}
+
+func newTrackIndex() *trackIndex {
+	return &trackIndex{tracks: make(map[string]*list.Element), order: list.New(), max: maxIndexedTracks}
+}
+
+// add indexes a track by its url, the requester is not kept.
+func (x *trackIndex) add(t *track) {
+	cached := *t
+	cached.requester = ""
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if e, ok := x.tracks[t.url]; ok {
+		e.Value = &cached
+		x.order.MoveToFront(e)
+		return
+	}
+
+	x.tracks[t.url] = x.order.PushFront(&cached)
+	for x.order.Len() > x.max {
+		oldest := x.order.Back()
+		x.order.Remove(oldest)
+		delete(x.tracks, oldest.Value.(*track).url)
+	}
+}
+
+// get returns a copy of the track with an url.
+func (x *trackIndex) get(url string) (*track, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	e, ok := x.tracks[url]
+	if !ok {
+		return nil, false
+	}
+	x.order.MoveToFront(e)
+
+	found := *e.Value.(*track)
+	return &found, true
+}
+
+// suggest returns the indexed tracks matching the query sorted by
+// title, the index is not searched without a query.
+func (x *trackIndex) suggest(guildID, query string, limit int) []*track {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	x.mu.Lock()
+	var tracks []*track
+	for _, e := range x.tracks {
+		if t := e.Value.(*track); matchesWords(t.title, words) {
+			tracks = append(tracks, t)
+		}
+	}
+	x.mu.Unlock()
+
+	sort.Slice(tracks, func(i, j int) bool {
+		return tracks[i].title < tracks[j].title
+	})
+	if len(tracks) > limit {
+		tracks = tracks[:limit]
+	}
+	return tracks
+}
+
+// searchWords splits a query into lowercase words.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// matchesWords tells if every word starts a word of the title, so
+// a word that is still being typed matches too.
+func matchesWords(title string, words []string) bool {
+	titleWords := searchWords(title)
+	for _, w := range words {
+		found := false
+		for _, tw := range titleWords {
+			if strings.HasPrefix(tw, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
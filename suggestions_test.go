@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestTrackIndexEviction(t *testing.T) {
+	x := newTrackIndex()
+	x.max = 3
+
+	for i := 1; i <= 3; i++ {
+		x.add(&track{title: "track " + strconv.Itoa(i), url: strconv.Itoa(i)})
+	}
+
+	// using a track keeps it in the index
+	if _, ok := x.get("1"); !ok {
+		t.Fatal("track 1 should be indexed")
+	}
+	x.add(&track{title: "track 4", url: "4"})
+
+	if _, ok := x.get("2"); ok {
+		t.Error("the least recently used track should have been removed")
+	}
+	for _, url := range []string{"1", "3", "4"} {
+		if _, ok := x.get(url); !ok {
+			t.Errorf("track %s should still be indexed", url)
+		}
+	}
+	if len(x.tracks) != 3 || x.order.Len() != 3 {
+		t.Errorf("index has %d tracks and %d in order, want 3", len(x.tracks), x.order.Len())
+	}
+
+	// adding a track again updates it without growing the index
+	x.add(&track{title: "renamed", url: "3", requester: "1"})
+	got, _ := x.get("3")
+	if got.title != "renamed" || got.requester != "" || len(x.tracks) != 3 {
+		t.Errorf("got %+v with %d tracks indexed", got, len(x.tracks))
+	}
+
+	suggested := x.suggest("", "track", 10)
+	if len(suggested) != 2 || suggested[0].url != "1" || suggested[1].url != "4" {
+		t.Errorf("got suggestions %v, want tracks 1 and 4", suggested)
+	}
+}
+
+func TestTrackIndexCopies(t *testing.T) {
+	x := newTrackIndex()
+	played := &track{title: "song", url: "u", requester: "1"}
+	x.add(played)
+
+	got, _ := x.get("u")
+	got.requester = "2"
+	again, _ := x.get("u")
+	if again.requester != "" || played.requester != "1" {
+		t.Error("the index should keep its own copy of a track")
+	}
+}
+
+func TestMatchesWords(t *testing.T) {
+	tests := []struct {
+		title, query string
+		match        bool
+	}{
+		{"Daft Punk - One More Time", "one more", true},
+		{"Daft Punk - One More Time", "daft ti", true},
+		{"Daft Punk - One More Time", "punk two", false},
+		{"Daft Punk - One More Time", "", true},
+		{"Björk - Jóga", "jóg", true},
+	}
+
+	for _, test := range tests {
+		if got := matchesWords(test.title, searchWords(test.query)); got != test.match {
+			t.Errorf("matchesWords(%q, %q) = %v, want %v", test.title, test.query, got, test.match)
+		}
+	}
+}
@@ -30,14 +30,25 @@ type voice struct {
 	udpConn             net.Conn
 	opusReceiver        chan []byte
 	connected           chan error
+	voiceUpdates        chan payload
+	done                chan struct{}
+	gw                  *gateway
+	guildID             string
+	leaveMu             sync.Mutex // guards leaving and done
+	leaving             bool
 	firstConnectionMade bool
 	running             bool
 }
 
+// errVoiceClosed is returned when sending audio after leaving voice
+var errVoiceClosed = errors.New("voice connection is closed")
+
 func newVoice() *voice {
 	v := voice{}
 	v.connected = make(chan error)
 	v.opusReceiver = make(chan []byte)
+	v.voiceUpdates = make(chan payload, 2)
+	v.done = make(chan struct{})
 	return &v
 }
 
@@ -45,17 +56,23 @@ func newVoice() *voice {
 // 	//TODO check if bot is in voice channel
 // }
 
-func (v *voice) establishConnection(channelID string, gw *gateway) (chan error, error) {
-	// TODO channelID should be picked from GUILDE state voice_states
-	// check if user_id exist in voice_states and join that channel
-	// wheh requested by text command
-
+func (v *voice) establishConnection(guildID, channelID string, gw *gateway) (chan error, error) {
 	if v.running {
 		v.wsMux.Lock()
 		v.conn.Close()
 		v.wsMux.Unlock()
 	}
 
+	// a voice that has left needs a new done channel
+	v.leaveMu.Lock()
+	if v.leaving {
+		v.done = make(chan struct{})
+		v.leaving = false
+	}
+	v.leaveMu.Unlock()
+
+	v.gw = gw
+	v.guildID = guildID
 	v.currentChannelID = channelID
 
 	// if a server connection is already made only wait for the voiceStateUpdateEvent
@@ -66,8 +83,13 @@ func (v *voice) establishConnection(channelID string, gw *gateway) (chan error,
 		eventCount = 2
 	}
 
+	// throw away updates that arrived when no connection was requested
+	for len(v.voiceUpdates) > 0 {
+		<-v.voiceUpdates
+	}
+
 	go func() {
-		err := gw.requestVoice(channelID)
+		err := gw.requestVoice(guildID, channelID)
 		if err != nil {
 			log.Printf("failed to request voice connection: %v\n", err)
 		}
@@ -77,21 +99,21 @@ func (v *voice) establishConnection(channelID string, gw *gateway) (chan error,
 	for i := 0; i < eventCount; i++ {
 		var p payload
 		select {
-		case p = <-gw.voiceUpdateResponse:
+		case p = <-v.voiceUpdates:
 		case <-time.After(time.Second * 5):
 			return nil, fmt.Errorf("voice request response timedout")
 		}
 
 		if p.Type == voiceStateUpdateEvent {
 			var vstate voiceStateUpdateResponse
-			err := json.Unmarshal(p.EventData, &vstate)
+			err := p.EventData.decode(&vstate)
 			handleJSONError("could not unmarshal voiceStateUpdateResponse", err)
 			v.userInfo = vstate
 		}
 
 		if p.Type == voiceServerUpdateEvent {
 			var vServer voiceServerUpdate
-			err := json.Unmarshal(p.EventData, &vServer)
+			err := p.EventData.decode(&vServer)
 			handleJSONError("could not unmarshal voiceServerUpdate", err)
 			v.firstConnectionMade = true
 			v.serverInfo = vServer
@@ -164,6 +186,12 @@ func (v *voice) open() {
 				v.udpConn.Close()
 			}
 			v.running = false
+
+			// the connection was closed on purpose by leave
+			if v.hasLeft() {
+				return
+			}
+
 			v.connected <- fmt.Errorf("error reading voice ws message: %v", err)
 			return
 		}
@@ -178,7 +206,7 @@ func (v *voice) open() {
 
 		if p.Operation == 2 {
 			var ready voiceReady
-			err := json.Unmarshal(p.EventData, &ready)
+			err := p.EventData.decode(&ready)
 			handleJSONError("error parsing ready event", err)
 
 			v.udpInfo = ready
@@ -193,7 +221,7 @@ func (v *voice) open() {
 
 		if p.Operation == 8 {
 			var he voiceHello
-			err := json.Unmarshal(p.EventData, &he)
+			err := p.EventData.decode(&he)
 			handleJSONError("error parsing hello event", err)
 
 			interval = float32(he.HeartbeatInterval) * 0.75
@@ -204,7 +232,7 @@ func (v *voice) open() {
 		// session description
 		if p.Operation == 4 {
 			var sd sessionDescription
-			err := json.Unmarshal(p.EventData, &sd)
+			err := p.EventData.decode(&sd)
 			handleJSONError("error parsing description", err)
 
 			v.encryptionMode = sd.Encryption
@@ -304,8 +332,15 @@ func (v *voice) establishUDPConnection() error {
 	return nil
 }
 
-func (v *voice) sendOpusData(data []byte) {
-	v.opusReceiver <- data
+// sendOpusData queues an opus frame to be sent, it fails
+// instead of blocking when the voice connection is left.
+func (v *voice) sendOpusData(data []byte) error {
+	select {
+	case v.opusReceiver <- data:
+		return nil
+	case <-v.closed():
+		return errVoiceClosed
+	}
 }
 
 func (v *voice) startOpusSender() {
@@ -327,12 +362,17 @@ func (v *voice) startOpusSender() {
 	var sequnce uint16
 	var nonce [24]byte
 	var frame []byte
+	done := v.closed()
 
 	v.running = true
 	v.connected <- nil
 
 	for {
-		frame = <-v.opusReceiver
+		select {
+		case frame = <-v.opusReceiver:
+		case <-done:
+			return
+		}
 
 		binary.BigEndian.PutUint16(RTPHeader[2:], sequnce)
 		sequnce++
@@ -393,6 +433,55 @@ func (v *voice) reconnect() {
 	v.open()
 }
 
+// hasLeft reports if leave was called since the last connection.
+func (v *voice) hasLeft() bool {
+	v.leaveMu.Lock()
+	defer v.leaveMu.Unlock()
+	return v.leaving
+}
+
+// closed returns the channel that is closed when the voice is left.
+func (v *voice) closed() chan struct{} {
+	v.leaveMu.Lock()
+	defer v.leaveMu.Unlock()
+	return v.done
+}
+
+// leave disconnects from the voice channel and closes the websocket and
+// UDP connections. Anyone sending opus data is released, and a later
+// establishConnection will make a new handshake with the voice server.
+func (v *voice) leave() error {
+	v.leaveMu.Lock()
+	if v.leaving {
+		v.leaveMu.Unlock()
+		return nil
+	}
+	v.leaving = true
+	close(v.done)
+	v.leaveMu.Unlock()
+
+	var err error
+	if v.gw != nil {
+		err = v.gw.leaveVoice(v.guildID)
+	}
+
+	// closing the websocket makes open stop the heart
+	v.wsMux.Lock()
+	if v.conn != nil {
+		v.conn.Close()
+	}
+	v.wsMux.Unlock()
+
+	if v.udpConn != nil {
+		v.udpConn.Close()
+	}
+
+	v.currentChannelID = ""
+	v.firstConnectionMade = false
+	v.running = false
+	return err
+}
+
 func (v *voice) speaking(b bool) error {
 	type voiceSpeakingData struct {
 		Speaking bool `json:"speaking"`
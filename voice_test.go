@@ -0,0 +1,28 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestVoiceLeaveConcurrent(t *testing.T) {
+	v := newVoice()
+
+	// both callers may see the voice as not left yet, only one closes done
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v.leave()
+		}()
+	}
+	wg.Wait()
+
+	if !v.hasLeft() {
+		t.Error("voice should have left")
+	}
+	if err := v.sendOpusData([]byte{0}); err != errVoiceClosed {
+		t.Errorf("sendOpusData after leave = %v, want %v", err, errVoiceClosed)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// voiceManager owns one voice connection for every guild and routes the
+// voice events from the gateway to the connection of their guild.
+type voiceManager struct {
+	mu     sync.Mutex
+	shards *shardManager
+	state  *state
+	voices map[string]*voice
+}
+
+func newVoiceManager(shards *shardManager, s *state) *voiceManager {
+	vm := voiceManager{
+		shards: shards,
+		state:  s,
+		voices: make(map[string]*voice)}
+
+	shards.events.addHandler(voiceStateUpdateEvent, func(data eventData) {
+		vm.route(voiceStateUpdateEvent, data)
+	})
+	shards.events.addHandler(voiceServerUpdateEvent, func(data eventData) {
+		vm.route(voiceServerUpdateEvent, data)
+	})
+
+	return &vm
+}
+
+// route passes a voice event to the voice connection of its guild, voice
+// states are only passed on when they are about the bot itself.
+func (vm *voiceManager) route(event string, data eventData) {
+	var target struct {
+		GuildID string `json:"guild_id"`
+		UserID  string `json:"user_id"`
+	}
+	if !decodeEvent(event, data, &target) {
+		return
+	}
+
+	if event == voiceStateUpdateEvent && target.UserID != vm.state.botUser().ID {
+		return
+	}
+
+	v, ok := vm.get(target.GuildID)
+	if !ok {
+		return
+	}
+
+	select {
+	case v.voiceUpdates <- payload{Type: event, EventData: data}:
+	default:
+		log.Printf("dropped %s for guild %s, no connection is waiting for it\n", event, target.GuildID)
+	}
+}
+
+// join connects to a voice channel, the voice connection of the guild is
+// created if there is none. The returned channel works as the one from
+// voice.establishConnection.
+func (vm *voiceManager) join(guildID, channelID string) (*voice, chan error, error) {
+	vm.mu.Lock()
+	v, ok := vm.voices[guildID]
+	if !ok {
+		v = newVoice()
+		vm.voices[guildID] = v
+	}
+	vm.mu.Unlock()
+
+	connected, err := v.establishConnection(guildID, channelID, vm.shards.shardFor(guildID))
+	return v, connected, err
+}
+
+// leave disconnects from voice in a guild.
+func (vm *voiceManager) leave(guildID string) error {
+	vm.mu.Lock()
+	v, ok := vm.voices[guildID]
+	delete(vm.voices, guildID)
+	vm.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return v.leave()
+}
+
+// get returns the voice connection of a guild.
+func (vm *voiceManager) get(guildID string) (*voice, bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	v, ok := vm.voices[guildID]
+	return v, ok
+}
+
+// all returns the voice connections of every guild.
+func (vm *voiceManager) all() []*voice {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	voices := make([]*voice, 0, len(vm.voices))
+	for _, v := range vm.voices {
+		voices = append(voices, v)
+	}
+	return voices
+}
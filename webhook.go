@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// interactionDeadline is how long Discord waits for the first response
+	interactionDeadline = time.Second * 3
+	maxInteractionSize  = 1 << 20
+	// maxTimestampAge is how old the signed timestamp of a request can be,
+	// older requests are refused so they can not be replayed
+	maxTimestampAge = time.Minute * 5
+)
+
+// interactionServer receives interactions from Discord as http requests
+// instead of over the gateway. Every request is verified with the public
+// key of the application and handled by the same registry as the gateway.
+type interactionServer struct {
+	publicKey ed25519.PublicKey
+	registry  *commandRegistry
+}
+
+// newInteractionServer creates the server from the hex encoded public key
+// shown on the application page of the developer portal.
+func newInteractionServer(publicKey string, registry *commandRegistry) (*interactionServer, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key: %v", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+	}
+
+	return &interactionServer{ed25519.PublicKey(key), registry}, nil
+}
+
+func (s *interactionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxInteractionSize))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+
+	// Discord sends requests with invalid signatures to check that they
+	// are refused, these have to be answered with 401
+	if !s.verify(r.Header.Get("X-Signature-Ed25519"), r.Header.Get("X-Signature-Timestamp"), body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var i interaction
+	err = json.Unmarshal(body, &i)
+	if err != nil {
+		http.Error(w, "invalid interaction", http.StatusBadRequest)
+		return
+	}
+
+	// the handler keeps running after the first response, so it can
+	// edit a deferred response or send follow-ups
+	resp := newWebhookResponder()
+	go s.registry.handle(&i, resp.respond)
+
+	ir, ok := resp.wait(interactionDeadline)
+	if !ok {
+		log.Printf("interaction %s was not responded to in time\n", i.ID)
+		http.Error(w, "no response", http.StatusInternalServerError)
+		return
+	}
+
+	// messages with files are answered with multipart/form-data
+	contentType, respBody, err := ir.encode()
+	if err != nil {
+		log.Printf("error encoding interaction response: %v\n", err)
+		http.Error(w, "invalid response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, err = w.Write(respBody)
+	if err != nil {
+		log.Printf("error writing interaction response: %v\n", err)
+	}
+}
+
+// verify checks the ed25519 signature of the timestamp and the body, and
+// that the timestamp is recent.
+func (s *interactionServer) verify(signature, timestamp string, body []byte) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+
+	// the timestamp is in unix seconds
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > maxTimestampAge || age < -maxTimestampAge {
+		return false
+	}
+
+	msg := make([]byte, 0, len(timestamp)+len(body))
+	msg = append(msg, timestamp...)
+	msg = append(msg, body...)
+	return ed25519.Verify(s.publicKey, msg, sig)
+}
+
+// webhookResponder passes the first response of a handler back to the
+// http request, which writes it as the body of the http response.
+type webhookResponder struct {
+	mu      sync.Mutex
+	c       chan *interactionResponse
+	expired bool
+}
+
+// errResponseExpired is returned when responding after the deadline
+var errResponseExpired = errors.New("the http request of the interaction has ended")
+
+func newWebhookResponder() *webhookResponder {
+	return &webhookResponder{c: make(chan *interactionResponse, 1)}
+}
+
+func (r *webhookResponder) respond(ir *interactionResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.expired {
+		return errResponseExpired
+	}
+	r.c <- ir
+	return nil
+}
+
+// wait returns the first response, later responses fail once the
+// timeout has passed.
+func (r *webhookResponder) wait(timeout time.Duration) (*interactionResponse, bool) {
+	select {
+	case ir := <-r.c:
+		return ir, true
+	case <-time.After(timeout):
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expired = true
+
+	// the response may have been sent right as the timeout passed
+	select {
+	case ir := <-r.c:
+		return ir, true
+	default:
+		return nil, false
+	}
+}
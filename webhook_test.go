@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testInteractionServer starts an interaction server for registry, it
+// returns the url and the key requests are signed with.
+func testInteractionServer(t *testing.T, registry *commandRegistry) (string, ed25519.PrivateKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newInteractionServer(hex.EncodeToString(public), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server.URL, private
+}
+
+// postInteraction sends body signed with key at the time ts, a nil key
+// sends the request without a signature.
+func postInteraction(t *testing.T, url string, key ed25519.PrivateKey, ts time.Time, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		sig := ed25519.Sign(key, []byte(timestamp+body))
+		req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(sig))
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestInteractionServerPing(t *testing.T) {
+	url, key := testInteractionServer(t, newCommandRegistry(nil))
+
+	resp := postInteraction(t, url, key, time.Now(), `{"id":"1","type":1}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q, want application/json", ct)
+	}
+
+	var ir interactionResponse
+	err := json.NewDecoder(resp.Body).Decode(&ir)
+	if err != nil || ir.Type != responsePong {
+		t.Errorf("got response %+v, %v, want a pong", ir, err)
+	}
+}
+
+func TestInteractionServerSignature(t *testing.T) {
+	url, key := testInteractionServer(t, newCommandRegistry(nil))
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	ping := `{"id":"1","type":1}`
+
+	tests := []struct {
+		name string
+		key  ed25519.PrivateKey
+		ts   time.Time
+	}{
+		{"missing signature", nil, time.Now()},
+		{"other key", otherKey, time.Now()},
+		{"stale timestamp", key, time.Now().Add(-maxTimestampAge - time.Minute)},
+		{"future timestamp", key, time.Now().Add(maxTimestampAge + time.Minute)},
+	}
+
+	for _, test := range tests {
+		resp := postInteraction(t, url, test.key, test.ts, ping)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want 401", test.name, resp.StatusCode)
+		}
+	}
+
+	// the body is signed together with the timestamp
+	req, _ := http.NewRequest("POST", url, strings.NewReader(ping))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(timestamp+`{"id":"2","type":1}`))))
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("changed body: got status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestInteractionServerDeferred(t *testing.T) {
+	registry := newCommandRegistry(nil)
+	done := make(chan error, 1)
+	registry.register(applicationCommand{Name: "slow"}, func(ctx *interactionContext) {
+		done <- ctx.deferReply()
+	})
+	url, key := testInteractionServer(t, registry)
+
+	resp := postInteraction(t, url, key, time.Now(), `{"id":"1","type":2,"data":{"name":"slow"},"token":"token"}`)
+	var ir interactionResponse
+	err := json.NewDecoder(resp.Body).Decode(&ir)
+	if err != nil || ir.Type != responseDeferredChannelMessage || ir.Data != nil {
+		t.Errorf("got response %+v, %v, want a deferred message", ir, err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("deferReply: %v", err)
+	}
+}
+
+func TestInteractionServerFiles(t *testing.T) {
+	registry := newCommandRegistry(nil)
+	registry.register(applicationCommand{Name: "lyrics"}, func(ctx *interactionContext) {
+		ctx.reply(&messageSend{
+			Content: "Here are the lyrics",
+			Files:   []*messageFile{{"lyrics.txt", bytes.NewReader([]byte("la la la"))}}})
+	})
+	url, key := testInteractionServer(t, registry)
+
+	resp := postInteraction(t, url, key, time.Now(), `{"id":"1","type":2,"data":{"name":"lyrics"},"token":"token"}`)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("got content type %q, want multipart/form-data", resp.Header.Get("Content-Type"))
+	}
+
+	form, err := multipart.NewReader(resp.Body, params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ir struct {
+		Type int         `json:"type"`
+		Data messageSend `json:"data"`
+	}
+	err = json.Unmarshal([]byte(form.Value["payload_json"][0]), &ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := attachmentSend{ID: 0, Filename: "lyrics.txt"}
+	if ir.Type != responseChannelMessage || ir.Data.Content != "Here are the lyrics" || len(ir.Data.Attachments) != 1 || ir.Data.Attachments[0] != want {
+		t.Errorf("got payload %+v", ir)
+	}
+
+	files := form.File["files[0]"]
+	if len(files) != 1 || files[0].Filename != "lyrics.txt" {
+		t.Fatalf("got files %v, want lyrics.txt", form.File)
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, _ := ioutil.ReadAll(f)
+	if string(b) != "la la la" {
+		t.Errorf("got file content %q", b)
+	}
+}
+
+func TestInteractionServerMethod(t *testing.T) {
+	url, _ := testInteractionServer(t, newCommandRegistry(nil))
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want 405", resp.StatusCode)
+	}
+}
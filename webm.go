@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Matroska element ids used by the demuxer
+const (
+	ebmlHeaderID        = 0x1A45DFA3
+	segmentID           = 0x18538067
+	tracksID            = 0x1654AE6B
+	trackEntryID        = 0xAE
+	trackNumberID       = 0xD7
+	trackTypeID         = 0x83
+	codecIDID           = 0x86
+	audioID             = 0xE1
+	samplingFrequencyID = 0xB5
+	channelsID          = 0x9F
+	clusterID           = 0x1F43B675
+	blockGroupID        = 0xA0
+	blockID             = 0xA1
+	simpleBlockID       = 0xA3
+
+	trackTypeAudio = 2
+
+	// maxWebmElementSize is the largest element read into memory, a
+	// block of opus frames is a few kilobytes
+	maxWebmElementSize = 1 << 22
+)
+
+// webmTrack holds the track information needed to play the audio
+type webmTrack struct {
+	number            uint64
+	trackType         uint64
+	codecID           string
+	samplingFrequency float64
+	channels          uint64
+}
+
+// webmReader is a minimal streaming WebM/Matroska demuxer, it returns
+// the frames of the first audio track in the order they are stored.
+type webmReader struct {
+	r       *bufio.Reader
+	tracks  []*webmTrack
+	audio   *webmTrack
+	pending [][]byte
+}
+
+// newWebmReader reads the header and track information from r, reading
+// stops at the first cluster so the frames can be read with readPacket.
+func newWebmReader(r io.Reader) (*webmReader, error) {
+	w := webmReader{r: bufio.NewReaderSize(r, 16384)}
+
+	id, size, err := w.element()
+	if err != nil {
+		return nil, fmt.Errorf("error reading webm header: %v", err)
+	}
+	if id != ebmlHeaderID {
+		return nil, errors.New("not a webm or matroska file")
+	}
+	err = w.skip(size)
+	if err != nil {
+		return nil, err
+	}
+
+	var track *webmTrack
+	for {
+		id, size, err := w.element()
+		if err != nil {
+			return nil, fmt.Errorf("error reading webm tracks: %v", err)
+		}
+
+		switch id {
+		case segmentID, tracksID, audioID:
+			// master elements, their children are read next
+
+		case trackEntryID:
+			track = &webmTrack{}
+			w.tracks = append(w.tracks, track)
+
+		case trackNumberID, trackTypeID, channelsID:
+			v, err := w.readUint(size)
+			if err != nil {
+				return nil, err
+			}
+			if track == nil {
+				continue
+			}
+
+			switch id {
+			case trackNumberID:
+				track.number = v
+			case trackTypeID:
+				track.trackType = v
+			case channelsID:
+				track.channels = v
+			}
+
+		case codecIDID:
+			b, err := w.read(size)
+			if err != nil {
+				return nil, err
+			}
+			if track != nil {
+				track.codecID = string(b)
+			}
+
+		case samplingFrequencyID:
+			f, err := w.readFloat(size)
+			if err != nil {
+				return nil, err
+			}
+			if track != nil {
+				track.samplingFrequency = f
+			}
+
+		case clusterID:
+			for _, t := range w.tracks {
+				if t.trackType == trackTypeAudio {
+					w.audio = t
+					return &w, nil
+				}
+			}
+			return nil, errors.New("webm file has no audio track")
+
+		default:
+			err := w.skip(size)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// isOpus reports if the audio can be sent to Discord without re-encoding.
+func (w *webmReader) isOpus() bool {
+	return w.audio.codecID == "A_OPUS" && w.audio.samplingFrequency == 48000
+}
+
+// readPacket returns the next frame of the audio track.
+func (w *webmReader) readPacket() ([]byte, error) {
+	for len(w.pending) == 0 {
+		id, size, err := w.element()
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case segmentID, clusterID, blockGroupID:
+			// master elements, their children are read next
+
+		case simpleBlockID, blockID:
+			b, err := w.read(size)
+			if err != nil {
+				return nil, err
+			}
+			err = w.parseBlock(b)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			err := w.skip(size)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	frame := w.pending[0]
+	w.pending = w.pending[1:]
+	return frame, nil
+}
+
+// parseBlock adds the frames in a block of the audio track to pending.
+func (w *webmReader) parseBlock(b []byte) error {
+	track, n := vint(b)
+	if n == 0 {
+		return errors.New("invalid webm block track number")
+	}
+	if track != w.audio.number {
+		return nil
+	}
+
+	// the track number is followed by a 16 bit timecode and the flags
+	if len(b) < n+3 {
+		return errors.New("webm block is too short")
+	}
+	flags := b[n+2]
+	b = b[n+3:]
+
+	lacing := (flags >> 1) & 0x03
+	if lacing == 0 {
+		w.pending = append(w.pending, b)
+		return nil
+	}
+
+	if len(b) < 1 {
+		return errors.New("webm block is missing lace count")
+	}
+	count := int(b[0]) + 1
+	b = b[1:]
+
+	sizes := make([]int, count)
+	switch lacing {
+	case 1: // Xiph lacing, each size is a sum of bytes ending below 255
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(b) == 0 {
+					return errors.New("invalid xiph lacing")
+				}
+				c := b[0]
+				b = b[1:]
+				sizes[i] += int(c)
+				if c != 255 {
+					break
+				}
+			}
+		}
+
+	case 2: // fixed size lacing
+		for i := range sizes {
+			sizes[i] = len(b) / count
+		}
+
+	case 3: // EBML lacing
+		first, n := vint(b)
+		if n == 0 {
+			return errors.New("invalid ebml lacing")
+		}
+		b = b[n:]
+		sizes[0] = int(first)
+
+		for i := 1; i < count-1; i++ {
+			diff, n := vint(b)
+			if n == 0 {
+				return errors.New("invalid ebml lacing")
+			}
+			b = b[n:]
+
+			// the differences are signed by subtracting half the range
+			bias := int64(1)<<(uint(7*n)-1) - 1
+			sizes[i] = sizes[i-1] + int(int64(diff)-bias)
+		}
+	}
+
+	if lacing != 2 {
+		total := 0
+		for _, s := range sizes[:count-1] {
+			total += s
+		}
+		sizes[count-1] = len(b) - total
+	}
+
+	for _, s := range sizes {
+		if s < 0 || s > len(b) {
+			return errors.New("webm lace sizes are larger than the block")
+		}
+		w.pending = append(w.pending, b[:s])
+		b = b[s:]
+	}
+
+	return nil
+}
+
+// element reads the id and size of the next element, size is -1 when
+// the size is unknown which is allowed for segments and clusters.
+func (w *webmReader) element() (uint32, int64, error) {
+	b, err := w.readVint()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(b) > 4 {
+		return 0, 0, errors.New("invalid ebml element id")
+	}
+
+	// ids keep their length marker
+	var id uint32
+	for _, c := range b {
+		id = id<<8 | uint32(c)
+	}
+
+	b, err = w.readVint()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, _ := vint(b)
+	if size == 1<<uint(7*len(b))-1 {
+		return id, -1, nil
+	}
+
+	return id, int64(size), nil
+}
+
+// readVint reads the bytes of the next variable size integer.
+func (w *webmReader) readVint() ([]byte, error) {
+	first, err := w.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	length := vintLength(first[0])
+	if length == 0 {
+		return nil, errors.New("invalid ebml variable size integer")
+	}
+
+	return w.read(int64(length))
+}
+
+func (w *webmReader) read(size int64) ([]byte, error) {
+	if size < 0 {
+		return nil, errors.New("ebml element has unknown size")
+	}
+	if size > maxWebmElementSize {
+		return nil, fmt.Errorf("ebml element of %d bytes is too large", size)
+	}
+
+	b := make([]byte, size)
+	_, err := io.ReadFull(w.r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (w *webmReader) skip(size int64) error {
+	if size < 0 {
+		return errors.New("ebml element has unknown size")
+	}
+
+	_, err := io.CopyN(ioutil.Discard, w.r, size)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (w *webmReader) readUint(size int64) (uint64, error) {
+	if size > 8 {
+		return 0, errors.New("ebml integer is too large")
+	}
+
+	b, err := w.read(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (w *webmReader) readFloat(size int64) (float64, error) {
+	if size != 4 && size != 8 {
+		return 0, errors.New("invalid ebml float size")
+	}
+
+	b, err := w.read(size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch size {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	default:
+		return 0, errors.New("invalid ebml float size")
+	}
+}
+
+// vint decodes an EBML variable size integer without its length
+// marker. The returned length is 0 for invalid data.
+func vint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+
+	length := vintLength(b[0])
+	if length == 0 || len(b) < length {
+		return 0, 0
+	}
+
+	v := uint64(b[0]) & (0xFF >> uint(length))
+	for _, c := range b[1:length] {
+		v = v<<8 | uint64(c)
+	}
+	return v, length
+}
+
+// vintLength returns the length of a variable size integer given its
+// first byte, the number of leading zero bits plus one.
+func vintLength(first byte) int {
+	for length := 1; length <= 8; length++ {
+		if first&(0x80>>uint(length-1)) != 0 {
+			return length
+		}
+	}
+	return 0
+}
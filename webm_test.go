@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// ebml builds an element with a known size.
+func ebml(id uint32, children ...[]byte) []byte {
+	var payload []byte
+	for _, c := range children {
+		payload = append(payload, c...)
+	}
+
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> uint(shift)); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+
+	if len(payload) < 127 {
+		out = append(out, 0x80|byte(len(payload)))
+	} else {
+		out = append(out, 0x40|byte(len(payload)>>8), byte(len(payload)))
+	}
+	return append(out, payload...)
+}
+
+func bufioReader(b []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(b))
+}
+
+// unknownSize builds the start of an element with an unknown size.
+func unknownSize(id uint32) []byte {
+	b := ebml(id)
+	return append(b[:len(b)-1], 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+}
+
+// webmFile builds a webm file with one opus track and one block per packet.
+func webmFile(packets ...[]byte) []byte {
+	rate := make([]byte, 8)
+	binary.BigEndian.PutUint64(rate, math.Float64bits(48000))
+
+	file := ebml(ebmlHeaderID)
+	file = append(file, unknownSize(segmentID)...)
+	file = append(file, ebml(tracksID, ebml(trackEntryID,
+		ebml(trackNumberID, []byte{1}),
+		ebml(trackTypeID, []byte{trackTypeAudio}),
+		ebml(codecIDID, []byte("A_OPUS")),
+		ebml(audioID, ebml(samplingFrequencyID, rate))))...)
+	file = append(file, unknownSize(clusterID)...)
+
+	for _, p := range packets {
+		block := append([]byte{0x81, 0, 0, 0x80}, p...)
+		file = append(file, ebml(simpleBlockID, block)...)
+	}
+	return file
+}
+
+func TestVint(t *testing.T) {
+	tests := []struct {
+		data   []byte
+		value  uint64
+		length int
+	}{
+		{[]byte{0x81}, 1, 1},
+		{[]byte{0xFF}, 127, 1},
+		{[]byte{0x40, 0x02}, 2, 2},
+		{[]byte{0x20, 0x01, 0x00}, 256, 3},
+		{[]byte{0x01, 0, 0, 0, 0, 0, 0, 5}, 5, 8},
+		{[]byte{0x81, 0xFF}, 1, 1},
+		{[]byte{0x00}, 0, 0},
+		{[]byte{0x40}, 0, 0},
+		{nil, 0, 0},
+	}
+
+	for _, test := range tests {
+		v, n := vint(test.data)
+		if v != test.value || n != test.length {
+			t.Errorf("vint(%x) = %d, %d, want %d, %d", test.data, v, n, test.value, test.length)
+		}
+	}
+}
+
+func TestWebmElement(t *testing.T) {
+	tests := []struct {
+		data []byte
+		id   uint32
+		size int64
+	}{
+		{[]byte{0xA3, 0x83}, simpleBlockID, 3},
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3, 0x40, 0x10}, ebmlHeaderID, 16},
+		{unknownSize(segmentID), segmentID, -1},
+		{[]byte{0x1F, 0x43, 0xB6, 0x75, 0xFF}, clusterID, -1},
+		{[]byte{0x1F, 0x43, 0xB6, 0x75, 0x7F, 0xFF}, clusterID, -1},
+	}
+
+	for _, test := range tests {
+		w := webmReader{r: bufioReader(test.data)}
+		id, size, err := w.element()
+		if err != nil {
+			t.Errorf("element(%x): %v", test.data, err)
+			continue
+		}
+		if id != test.id || size != test.size {
+			t.Errorf("element(%x) = %x, %d, want %x, %d", test.data, id, size, test.id, test.size)
+		}
+	}
+
+	for _, bad := range [][]byte{{0x08, 1, 2, 3, 4, 0x81}, {0xA3}, {0x00}} {
+		w := webmReader{r: bufioReader(bad)}
+		if _, _, err := w.element(); err == nil {
+			t.Errorf("element(%x) should fail", bad)
+		}
+	}
+}
+
+func TestParseBlock(t *testing.T) {
+	a := bytes.Repeat([]byte{'a'}, 300)
+	b := []byte("bb")
+	c := []byte("ccc")
+
+	block := func(track, flags byte, data ...[]byte) []byte {
+		out := []byte{0x80 | track, 0, 0, flags}
+		for _, d := range data {
+			out = append(out, d...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		block  []byte
+		frames [][]byte
+	}{
+		{"no lacing", block(1, 0x80, c), [][]byte{c}},
+		{"other track", block(2, 0x80, c), nil},
+		{"xiph", block(1, 0x02, []byte{2, 255, 45, 2}, a, b, c), [][]byte{a, b, c}},
+		{"fixed", block(1, 0x04, []byte{2}, c, c, c), [][]byte{c, c, c}},
+		// sizes 4 and 6 are a size and a signed difference of +2
+		{"ebml", block(1, 0x06, []byte{2, 0x84, 0x80 | 65}, a[:4], a[:6], c), [][]byte{a[:4], a[:6], c}},
+		{"ebml negative", block(1, 0x06, []byte{2, 0x86, 0x80 | 61}, a[:6], a[:4], c), [][]byte{a[:6], a[:4], c}},
+	}
+
+	for _, test := range tests {
+		w := webmReader{audio: &webmTrack{number: 1}}
+		err := w.parseBlock(test.block)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(w.pending, test.frames) {
+			t.Errorf("%s: got frames %q, want %q", test.name, w.pending, test.frames)
+		}
+	}
+
+	bad := map[string][]byte{
+		"short":             {0x81, 0},
+		"missing count":     block(1, 0x02),
+		"xiph too large":    block(1, 0x02, []byte{1, 200}, c),
+		"ebml invalid size": block(1, 0x06, []byte{1, 0x00}),
+	}
+	for name, b := range bad {
+		w := webmReader{audio: &webmTrack{number: 1}}
+		if err := w.parseBlock(b); err == nil {
+			t.Errorf("%s: parseBlock should fail", name)
+		}
+	}
+}
+
+func TestWebmSourceFrameSize(t *testing.T) {
+	// a CELT packet of 20 ms and a SILK packet of 60 ms
+	packet20 := []byte{31 << 3, 1, 2, 3}
+	packet60 := []byte{3 << 3, 1, 2, 3}
+
+	src, err := newWebmSource(bytes.NewReader(webmFile(packet20, packet20)))
+	if err != nil {
+		t.Fatalf("20 ms packets should be passed through: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		p, err := src.readOpus()
+		if err != nil || !bytes.Equal(p, packet20) {
+			t.Errorf("frame %d: got %x, %v", i, p, err)
+		}
+	}
+
+	_, err = newWebmSource(bytes.NewReader(webmFile(packet60)))
+	if err == nil {
+		t.Error("60 ms packets should not be passed through")
+	}
+
+	// a later packet of another size ends the pass through
+	src, err = newWebmSource(bytes.NewReader(webmFile(packet20, packet60)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.readOpus()
+	if _, err := src.readOpus(); err == nil {
+		t.Error("a 60 ms packet after the first should fail")
+	}
+}
+
+func TestWebmElementTooLarge(t *testing.T) {
+	// a block of 2^55 bytes and one of 64 MB
+	for _, size := range [][]byte{
+		{0x01, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE},
+		{0x14, 0x00, 0x00, 0x00}} {
+		file := append(webmFile(), simpleBlockID)
+		file = append(file, size...)
+
+		w, err := newWebmReader(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.readPacket(); err == nil {
+			t.Errorf("block with size %x should fail", size)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// zlibSuffix ends every message of a zlib-stream, it is the
+// marker of a zlib sync flush
+var zlibSuffix = []byte{0x00, 0x00, 0xff, 0xff}
+
+// zlibWindow is the distance a deflate stream can refer back to
+const zlibWindow = 32768
+
+// zlibStream decompresses the zlib-stream transport compression, where
+// every message on a connection shares the same compression context.
+type zlibStream struct {
+	buf     []byte
+	reader  io.ReadCloser
+	history []byte
+}
+
+// inflate buffers a binary websocket message and returns the decompressed
+// payload once a message ending with the zlib flush suffix is received.
+func (z *zlibStream) inflate(b []byte) ([]byte, bool, error) {
+	z.buf = append(z.buf, b...)
+	if !bytes.HasSuffix(z.buf, zlibSuffix) {
+		return nil, false, nil
+	}
+	defer func() { z.buf = z.buf[:0] }()
+
+	if z.reader == nil {
+		// only the first message starts with the two byte zlib header
+		if len(z.buf) < 2 {
+			return nil, false, errors.New("zlib stream is missing its header")
+		}
+		z.reader = flate.NewReader(bytes.NewReader(z.buf[2:]))
+	} else {
+		// a flush ends on a block boundary, so continuing with the previous
+		// output as dictionary is the same as keeping the inflater running
+		err := z.reader.(flate.Resetter).Reset(bytes.NewReader(z.buf), z.history)
+		if err != nil {
+			return nil, false, fmt.Errorf("error resetting inflater: %v", err)
+		}
+	}
+
+	// the inflater can not tell that the flush ended the message
+	// so running out of input is expected
+	out, err := ioutil.ReadAll(z.reader)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, false, fmt.Errorf("error inflating message: %v", err)
+	}
+
+	z.history = append(z.history, out...)
+	if len(z.history) > zlibWindow {
+		z.history = append([]byte(nil), z.history[len(z.history)-zlibWindow:]...)
+	}
+
+	return out, true, nil
+}
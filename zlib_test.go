@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"math/rand"
+	"testing"
+)
+
+// zlibMessages compresses messages the way Discord sends a zlib-stream, in
+// one compression context with a sync flush after every message.
+func zlibMessages(t *testing.T, messages ...string) [][]byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+
+	var out [][]byte
+	for _, m := range messages {
+		w.Write([]byte(m))
+		err := w.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasSuffix(buf.Bytes(), zlibSuffix) {
+			t.Fatal("flushed message does not end with the sync flush suffix")
+		}
+		out = append(out, append([]byte(nil), buf.Bytes()...))
+		buf.Reset()
+	}
+	return out
+}
+
+func TestZlibStreamFrames(t *testing.T) {
+	message := `{"op":0,"s":1,"t":"MESSAGE_CREATE","d":{"content":"` + string(bytes.Repeat([]byte("hello "), 200)) + `"}}`
+	compressed := zlibMessages(t, message)[0]
+
+	// the message is split over frames, only the last has the suffix
+	z := &zlibStream{}
+	frames := [][]byte{compressed[:1], compressed[1:10], compressed[10 : len(compressed)-2], compressed[len(compressed)-2:]}
+	for i, f := range frames[:len(frames)-1] {
+		out, complete, err := z.inflate(f)
+		if err != nil || complete || out != nil {
+			t.Fatalf("frame %d: got %q, %v, %v, want to wait for the rest", i, out, complete, err)
+		}
+	}
+
+	out, complete, err := z.inflate(frames[len(frames)-1])
+	if err != nil || !complete {
+		t.Fatalf("last frame: got %v, %v, want the message", complete, err)
+	}
+	if string(out) != message {
+		t.Errorf("got %q, want %q", out, message)
+	}
+}
+
+func TestZlibStreamSharedContext(t *testing.T) {
+	// text that does not compress on its own, so the second message can
+	// only be small by referring back to the first
+	r := rand.New(rand.NewSource(1))
+	name := make([]byte, 2000)
+	for i := range name {
+		name[i] = byte('a' + r.Intn(26))
+	}
+	first := `{"op":0,"t":"GUILD_CREATE","d":{"name":"` + string(name) + `"}}`
+	second := `{"op":0,"t":"GUILD_UPDATE","d":{"name":"` + string(name) + `"}}`
+	compressed := zlibMessages(t, first, second)
+	if len(compressed[1]) > len(second)/4 {
+		t.Fatalf("second message is %d bytes compressed, it should refer to the first", len(compressed[1]))
+	}
+
+	z := &zlibStream{}
+	for i, want := range []string{first, second} {
+		out, complete, err := z.inflate(compressed[i])
+		if err != nil || !complete || string(out) != want {
+			t.Errorf("message %d: got %d bytes, %v, %v, want %d bytes", i, len(out), complete, err, len(want))
+		}
+	}
+
+	// without the history of the first message the second can not be inflated
+	fresh := &zlibStream{}
+	out, _, err := fresh.inflate(append(compressed[0][:2:2], compressed[1]...))
+	if err == nil && string(out) == second {
+		t.Error("the second message should need the history of the stream")
+	}
+}